@@ -0,0 +1,113 @@
+package v1alpha1
+
+import (
+	"github.com/rancher/wrangler/pkg/condition"
+	"github.com/rancher/wrangler/pkg/genericcondition"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// AtlasCluster represents a downstream cluster whose Thanos, Prometheus and AlertManager
+// are exposed to the observability cluster through the Atlas Envoy mesh.
+type AtlasCluster struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AtlasClusterSpec   `json:"spec"`
+	Status AtlasClusterStatus `json:"status,omitempty"`
+}
+
+type AtlasClusterSpec struct {
+	// ExternalAddresses are the IPs or DNS names the downstream cluster's envoy is reachable on
+	ExternalAddresses []string `json:"externalAddresses"`
+
+	// Replicas is the number of prometheus/thanos replicas in the downstream cluster
+	Replicas int `json:"replicas,omitempty"`
+
+	Thanos       *ServiceEndpoint `json:"thanos,omitempty"`
+	Prometheus   *ServiceEndpoint `json:"prometheus,omitempty"`
+	AlertManager *ServiceEndpoint `json:"alertmanager,omitempty"`
+
+	// EnvoySelectors are the pod labels of the observability cluster envoy that the
+	// thanos sidecar services will select
+	EnvoySelectors map[string]string `json:"envoySelectors,omitempty"`
+
+	// HealthCheck configures the active health checks the observability cluster envoy runs against
+	// the thanos sidecar and prometheus of the downstream cluster
+	HealthCheck *HealthCheck `json:"healthCheck,omitempty"`
+
+	// Services are additional services of the downstream cluster that are exposed to the observability
+	// cluster through the Atlas Envoy mesh
+	Services []ExposedService `json:"services,omitempty"`
+}
+
+// HealthCheck configures the active health checks of a downstream cluster, unset fields use the defaults
+type HealthCheck struct {
+	// Disabled turns active health checking off, outlier detection still ejects failing endpoints
+	Disabled bool `json:"disabled,omitempty"`
+	// Interval between two health checks of an endpoint, defaults to 10s
+	Interval string `json:"interval,omitempty"`
+	// Timeout of a single health check, defaults to 5s
+	Timeout string `json:"timeout,omitempty"`
+	// UnhealthyThreshold is the number of failed health checks after which an endpoint is unhealthy, defaults to 3
+	UnhealthyThreshold uint32 `json:"unhealthyThreshold,omitempty"`
+	// HealthyThreshold is the number of passed health checks after which an endpoint is healthy again, defaults to 1
+	HealthyThreshold uint32 `json:"healthyThreshold,omitempty"`
+	// ThanosService is the service name of the gRPC health checks of the thanos sidecar, defaults to the overall health
+	ThanosService string `json:"thanosService,omitempty"`
+	// PrometheusPath is the path of the HTTP health checks of prometheus, defaults to /-/ready
+	PrometheusPath string `json:"prometheusPath,omitempty"`
+}
+
+// Protocols of exposed services
+const (
+	ServiceProtocolHTTP = "http"
+	ServiceProtocolGRPC = "grpc"
+	ServiceProtocolTCP  = "tcp"
+)
+
+// ExposedService is a service of the downstream cluster that is exposed to the observability cluster. HTTP
+// services are served by the observability cluster envoy under their path prefix followed by the cluster
+// name, gRPC and TCP services cannot be routed by path and get a port of their own.
+type ExposedService struct {
+	// Name identifies the service within the cluster, it must be a DNS label
+	Name string `json:"name"`
+	// Service is the fqdn of the service on the downstream cluster
+	Service string `json:"service"`
+	// Port of the service on the downstream cluster
+	Port uint32 `json:"port"`
+	// Protocol is one of http, grpc or tcp, defaults to http
+	Protocol string `json:"protocol,omitempty"`
+	// PathPrefix of http services on the observability cluster envoy, defaults to /<name>
+	PathPrefix string `json:"pathPrefix,omitempty"`
+	// ListenPort is the port grpc and tcp services are exposed on, it must be unique across all clusters
+	ListenPort uint32 `json:"listenPort,omitempty"`
+}
+
+// ServiceEndpoint overrides the service fqdn and port of a component in the downstream cluster.
+// This is mainly useful when the prometheus-operator is not being used.
+type ServiceEndpoint struct {
+	Service string `json:"service,omitempty"`
+	Port    uint32 `json:"port,omitempty"`
+}
+
+type AtlasClusterStatus struct {
+	Conditions          []genericcondition.GenericCondition `json:"conditions,omitempty"`
+	LastSnapshotVersion string                              `json:"lastSnapshotVersion,omitempty"`
+	CertSerial          string                              `json:"certSerial,omitempty"`
+}
+
+const (
+	// ClusterConditionReady is true when all other cluster conditions are true
+	ClusterConditionReady condition.Cond = "Ready"
+	// ClusterConditionProvisioned is true when the sidecar services and envoy values secret have been applied
+	ClusterConditionProvisioned condition.Cond = "Provisioned"
+	// ClusterConditionPKIIssued is true when certificate material has been issued to the cluster
+	ClusterConditionPKIIssued condition.Cond = "PKIIssued"
+	// ClusterConditionSnapshotPublished is true when the envoy-ads server published a snapshot for the cluster
+	ClusterConditionSnapshotPublished condition.Cond = "SnapshotPublished"
+	// ClusterConditionEnvoyConnected is true when the cluster's envoy has an open stream to the envoy-ads server
+	ClusterConditionEnvoyConnected condition.Cond = "EnvoyConnected"
+)
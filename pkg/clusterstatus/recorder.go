@@ -0,0 +1,144 @@
+package clusterstatus
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/rancher/wrangler/pkg/condition"
+	"github.com/rancher/wrangler/pkg/schemes"
+	"github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/goatlas-io/atlas/pkg/apis/atlas.goatlas.io/v1alpha1"
+	atlascontrollers "github.com/goatlas-io/atlas/pkg/generated/controllers/atlas.goatlas.io/v1alpha1"
+)
+
+// readyConditions are the conditions that must all be true for a cluster to be Ready
+var readyConditions = []condition.Cond{
+	v1alpha1.ClusterConditionProvisioned,
+	v1alpha1.ClusterConditionPKIIssued,
+	v1alpha1.ClusterConditionSnapshotPublished,
+	v1alpha1.ClusterConditionEnvoyConnected,
+}
+
+// Recorder writes status back onto AtlasCluster resources and mirrors every
+// condition transition as a Kubernetes event on the cluster resource.
+type Recorder struct {
+	clusters atlascontrollers.AtlasClusterController
+	events   record.EventRecorder
+	log      *logrus.Entry
+}
+
+func NewRecorder(kube kubernetes.Interface, clusters atlascontrollers.AtlasClusterController, component string) *Recorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{
+		Interface: kube.CoreV1().Events(""),
+	})
+
+	return &Recorder{
+		clusters: clusters,
+		events:   broadcaster.NewRecorder(schemes.All, corev1.EventSource{Component: component}),
+		log:      logrus.WithField("component", "cluster-status"),
+	}
+}
+
+// SetCondition sets the condition on the cluster to true when err is nil, otherwise false with the
+// error as the message. An event is recorded when the condition changed. Clusters that do not
+// exist are ignored, as is everything on a nil recorder.
+func (r *Recorder) SetCondition(namespace, name string, cond condition.Cond, reason string, err error) error {
+	if r == nil {
+		return nil
+	}
+
+	changed := false
+
+	cluster, updateErr := r.update(namespace, name, func(cluster *v1alpha1.AtlasCluster) {
+		if cond.MatchesError(cluster, reason, err) {
+			return
+		}
+
+		changed = true
+		cond.SetError(cluster, reason, err)
+		cond.LastUpdated(cluster, time.Now().UTC().Format(time.RFC3339))
+		setReady(cluster)
+	})
+	if updateErr != nil || cluster == nil || !changed {
+		return updateErr
+	}
+
+	if err != nil {
+		r.events.Event(cluster, corev1.EventTypeWarning, cond.GetReason(cluster), fmt.Sprintf("%s: %s", cond, err.Error()))
+	} else {
+		r.events.Event(cluster, corev1.EventTypeNormal, cond.GetReason(cluster), fmt.Sprintf("%s is true", cond))
+	}
+
+	return nil
+}
+
+// Update applies mutate to the latest version of the cluster status and writes it back
+// when anything changed, retrying on conflicts.
+func (r *Recorder) Update(namespace, name string, mutate func(status *v1alpha1.AtlasClusterStatus)) error {
+	if r == nil {
+		return nil
+	}
+
+	_, err := r.update(namespace, name, func(cluster *v1alpha1.AtlasCluster) {
+		mutate(&cluster.Status)
+	})
+	return err
+}
+
+func (r *Recorder) update(namespace, name string, mutate func(cluster *v1alpha1.AtlasCluster)) (*v1alpha1.AtlasCluster, error) {
+	var result *v1alpha1.AtlasCluster
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cluster, err := r.clusters.Get(namespace, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			result = nil
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		updated := cluster.DeepCopy()
+		mutate(updated)
+
+		if reflect.DeepEqual(cluster.Status, updated.Status) {
+			result = cluster
+			return nil
+		}
+
+		result, err = r.clusters.UpdateStatus(updated)
+		return err
+	})
+	if err != nil {
+		r.log.WithError(err).WithField("cluster", name).Error("unable to update cluster status")
+	}
+
+	return result, err
+}
+
+func setReady(cluster *v1alpha1.AtlasCluster) {
+	waiting := []string{}
+	for _, cond := range readyConditions {
+		if !cond.IsTrue(cluster) {
+			waiting = append(waiting, string(cond))
+		}
+	}
+
+	if len(waiting) == 0 {
+		v1alpha1.ClusterConditionReady.SetError(cluster, "Ready", nil)
+		return
+	}
+
+	v1alpha1.ClusterConditionReady.SetError(cluster, "Waiting", fmt.Errorf("waiting on %s", strings.Join(waiting, ", ")))
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+
+	v1alpha1 "github.com/goatlas-io/atlas/pkg/apis/atlas.goatlas.io/v1alpha1"
+	controllergen "github.com/rancher/wrangler/pkg/controller-gen"
+	"github.com/rancher/wrangler/pkg/controller-gen/args"
+
+	// Ensure gvk gets loaded in wrangler/pkg/gvk cache
+	_ "github.com/rancher/wrangler/pkg/generated/controllers/apiextensions.k8s.io/v1"
+)
+
+func main() {
+	os.Unsetenv("GOPATH")
+	controllergen.Run(args.Options{
+		OutputPackage: "github.com/goatlas-io/atlas/pkg/generated",
+		Boilerplate:   "scripts/boilerplate.go.txt",
+		Groups: map[string]args.Group{
+			"atlas.goatlas.io": {
+				Types: []interface{}{
+					v1alpha1.AtlasCluster{},
+				},
+				GenerateTypes: true,
+			},
+		},
+	})
+}
@@ -0,0 +1,131 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/rancher/wrangler/pkg/kubeconfig"
+	"github.com/rancher/wrangler/pkg/signals"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/goatlas-io/atlas/pkg/common"
+	"github.com/goatlas-io/atlas/pkg/pki"
+)
+
+type caCommand struct {
+}
+
+func (w *caCommand) client(c *cli.Context) (*kubernetes.Clientset, error) {
+	cfg, err := kubeconfig.GetNonInteractiveClientConfig(c.String("kubeconfig")).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return kubernetes.NewForConfig(cfg)
+}
+
+func (w *caCommand) List(c *cli.Context) error {
+	ctx := signals.SetupSignalHandler(context.Background())
+
+	kube, err := w.client(c)
+	if err != nil {
+		return err
+	}
+
+	secret, err := kube.CoreV1().Secrets(c.String("namespace")).Get(ctx, common.CASecretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	cas, err := pki.List(secret)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERIAL\tKEY\tSTATUS\tNOT BEFORE\tNOT AFTER\tRETIRED")
+	for _, ca := range cas {
+		retired := "-"
+		if ca.RetiredAt != nil {
+			retired = ca.RetiredAt.Format(time.RFC3339)
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", ca.Serial, ca.Key, ca.Status(now),
+			ca.NotBefore.Format(time.RFC3339), ca.NotAfter.Format(time.RFC3339), retired)
+	}
+
+	return tw.Flush()
+}
+
+func (w *caCommand) Retire(c *cli.Context) error {
+	ctx := signals.SetupSignalHandler(context.Background())
+
+	log := logrus.WithField("command", "ca-retire").WithField("serial", c.String("serial"))
+
+	kube, err := w.client(c)
+	if err != nil {
+		return err
+	}
+
+	secrets := kube.CoreV1().Secrets(c.String("namespace"))
+
+	secret, err := secrets.Get(ctx, common.CASecretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	if err := pki.Retire(secret, c.String("serial"), time.Now()); err != nil {
+		return err
+	}
+
+	if _, err := secrets.Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+		return err
+	}
+
+	log.Info("certificate authority retired, it will be removed from the trust bundle after the grace period")
+
+	return nil
+}
+
+func init() {
+	cmd := caCommand{}
+
+	namespaceFlag := &cli.StringFlag{
+		Name:  "namespace",
+		Usage: "namespace where atlas resources are located",
+		Value: common.MonitoringNamespace,
+	}
+
+	common.RegisterCommand(&cli.Command{
+		Name:   "ca-list",
+		Usage:  "list the certificate authorities in the atlas trust bundle",
+		Flags:  append([]cli.Flag{namespaceFlag}, globalFlags()...),
+		Before: globalBefore,
+		Action: cmd.List,
+	})
+
+	common.RegisterCommand(&cli.Command{
+		Name:  "ca-retire",
+		Usage: "retire a previous certificate authority so it is removed from the trust bundle",
+		Flags: append([]cli.Flag{
+			namespaceFlag,
+			&cli.StringFlag{
+				Name:     "serial",
+				Usage:    "Serial of the certificate authority to retire (see ca-list)",
+				Required: true,
+			},
+		}, globalFlags()...),
+		Before: globalBefore,
+		Action: cmd.Retire,
+	})
+}
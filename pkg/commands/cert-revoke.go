@@ -0,0 +1,140 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/rancher/wrangler/pkg/kubeconfig"
+	"github.com/rancher/wrangler/pkg/signals"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/goatlas-io/atlas/pkg/common"
+	"github.com/goatlas-io/atlas/pkg/pki"
+)
+
+type certRevokeCommand struct {
+}
+
+func (w *certRevokeCommand) Execute(c *cli.Context) error {
+	if (c.String("serial") == "") == (c.String("secret") == "") {
+		return fmt.Errorf("exactly one of --serial or --secret is required")
+	}
+
+	// set up signals so we handle the first shutdown signal gracefully
+	ctx := signals.SetupSignalHandler(context.Background())
+
+	log := logrus.WithField("command", "cert-revoke")
+
+	cfg, err := kubeconfig.GetNonInteractiveClientConfig(c.String("kubeconfig")).ClientConfig()
+	if err != nil {
+		return err
+	}
+
+	kube, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	secrets := kube.CoreV1().Secrets(c.String("namespace"))
+
+	// Revoking by secret is handled by the controller, which also issues a replacement certificate
+	if name := c.String("secret"); name != "" {
+		secret, err := secrets.Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if _, ok := secret.GetLabels()[common.IsCertLabel]; !ok {
+			return fmt.Errorf("secret %s is not an atlas certificate", name)
+		}
+
+		if secret.Annotations == nil {
+			secret.Annotations = map[string]string{}
+		}
+		secret.Annotations[common.RevokeAnnotation] = "true"
+
+		if _, err := secrets.Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+
+		log.WithField("secret", name).Info("certificate marked for revocation, the controller will revoke and reissue it")
+	}
+
+	caSecret, err := secrets.Get(ctx, common.CASecretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	if serial := c.String("serial"); serial != "" {
+		revocation := pki.Revocation{RevokedAt: time.Now()}
+
+		// The issuance log knows the expiry and issuer of certificates signed by Atlas, they allow
+		// the controller to prune the revocation once it no longer matters
+		issuanceLog, err := kube.CoreV1().ConfigMaps(c.String("namespace")).Get(ctx, common.IssuanceLogConfigMapName, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		} else if err == nil {
+			if record, err := pki.DecodeIssuanceRecord(issuanceLog.Data[serial]); err == nil {
+				revocation.NotAfter = &record.NotAfter
+				revocation.Issuer = record.IssuerSerial
+			}
+		}
+
+		if err := pki.Revoke(caSecret, serial, revocation); err != nil {
+			return err
+		}
+
+		if caSecret, err = secrets.Update(ctx, caSecret, metav1.UpdateOptions{}); err != nil {
+			return err
+		}
+
+		log.WithField("serial", serial).Info("certificate revoked")
+	}
+
+	uncovered, err := pki.CRLUncovered(caSecret)
+	if err != nil {
+		return err
+	}
+	if len(uncovered) > 0 {
+		log.WithField("cas", uncovered).Warn("envoy will not enforce revocations until these certificate authorities are rotated or retired")
+	}
+
+	return nil
+}
+
+func init() {
+	cmd := certRevokeCommand{}
+
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:  "serial",
+			Usage: "Serial of the certificate to revoke",
+		},
+		&cli.StringFlag{
+			Name:  "secret",
+			Usage: "Name of the secret holding the certificate to revoke, a new certificate is issued in its place",
+		},
+		&cli.StringFlag{
+			Name:  "namespace",
+			Usage: "namespace where atlas resources are located",
+			Value: common.MonitoringNamespace,
+		},
+	}
+
+	cliCmd := &cli.Command{
+		Name:   "cert-revoke",
+		Usage:  "revoke an atlas issued certificate",
+		Flags:  append(flags, globalFlags()...),
+		Before: globalBefore,
+		Action: cmd.Execute,
+	}
+
+	common.RegisterCommand(cliCmd)
+}
@@ -1,29 +1,23 @@
 package commands
 
 import (
-	"context"
-
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 
 	"github.com/rancher/wrangler/pkg/kubeconfig"
-	"github.com/rancher/wrangler/pkg/signals"
 
-	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/intstr"
-	"k8s.io/client-go/kubernetes"
 
+	"github.com/goatlas-io/atlas/pkg/apis/atlas.goatlas.io/v1alpha1"
 	"github.com/goatlas-io/atlas/pkg/common"
+	atlasv1 "github.com/goatlas-io/atlas/pkg/generated/controllers/atlas.goatlas.io"
 )
 
 type clusterAddCommand struct {
 }
 
 func (w *clusterAddCommand) Execute(c *cli.Context) error {
-	ctx := signals.SetupSignalHandler(context.Background())
-
 	log := logrus.WithField("command", "cluster-add").WithField("cluster", c.String("name"))
 
 	cfg, err := kubeconfig.GetNonInteractiveClientConfig(c.String("kubeconfig")).ClientConfig()
@@ -31,12 +25,14 @@ func (w *clusterAddCommand) Execute(c *cli.Context) error {
 		return err
 	}
 
-	kube, err := kubernetes.NewForConfig(cfg)
+	atlas, err := atlasv1.NewFactoryFromConfig(cfg)
 	if err != nil {
 		return err
 	}
 
-	service, err := kube.CoreV1().Services(c.String("namespace")).Get(ctx, c.String("name"), metav1.GetOptions{})
+	clusters := atlas.Atlas().V1alpha1().AtlasCluster()
+
+	cluster, err := clusters.Get(c.String("namespace"), c.String("name"), metav1.GetOptions{})
 	if err != nil && !apierrors.IsNotFound(err) {
 		return err
 	}
@@ -44,56 +40,28 @@ func (w *clusterAddCommand) Execute(c *cli.Context) error {
 	notfound := false
 	if apierrors.IsNotFound(err) {
 		notfound = true
+		cluster = &v1alpha1.AtlasCluster{}
 	}
 
-	log.Debug(service)
+	log.Debug(cluster)
 
 	if notfound || c.Bool("overwrite") {
-		newService := service.DeepCopy()
-		newService.ObjectMeta.Labels = map[string]string{
-			common.AtlasClusterLabel: "true",
-			common.ReplicasLabel:     c.String("replicas"),
-		}
-		newService.Spec = corev1.ServiceSpec{
-			ClusterIP:  "None",
-			ClusterIPs: []string{},
-			Ports: []corev1.ServicePort{
-				{
-					Port: 9090,
-					TargetPort: intstr.IntOrString{
-						IntVal: 9090,
-					},
-					Protocol: "TCP",
-					Name:     "prometheus",
-				},
-				{
-					Port: 10901,
-					TargetPort: intstr.IntOrString{
-						IntVal: 10901,
-					},
-					Protocol: "TCP",
-					Name:     "thanos",
-				},
-				{
-					Port: 9093,
-					TargetPort: intstr.IntOrString{
-						IntVal: 9093,
-					},
-					Protocol: "TCP",
-					Name:     "alertmanager",
-				},
-			},
-			ExternalIPs: c.StringSlice("external-ip"),
+		newCluster := cluster.DeepCopy()
+		newCluster.ObjectMeta.Name = c.String("name")
+		newCluster.ObjectMeta.Namespace = c.String("namespace")
+		newCluster.Spec = v1alpha1.AtlasClusterSpec{
+			ExternalAddresses: c.StringSlice("external-ip"),
+			Replicas:          c.Int("replicas"),
 		}
 
 		if notfound {
-			if _, err := kube.CoreV1().Services(c.String("namespace")).Create(ctx, newService, metav1.CreateOptions{}); err != nil {
+			if _, err := clusters.Create(newCluster); err != nil {
 				return err
 			}
 			log.Info("Cluster added successfully")
 		} else {
 			if c.Bool("overwrite") {
-				if _, err := kube.CoreV1().Services(c.String("namespace")).Update(ctx, newService, metav1.UpdateOptions{}); err != nil {
+				if _, err := clusters.Update(newCluster); err != nil {
 					return err
 				}
 				log.Info("Cluster updated successfully")
@@ -123,11 +91,11 @@ func init() {
 		&cli.StringFlag{
 			Name:  "namespace",
 			Usage: "namespace where atlas resources are located",
-			Value: "monitoring",
+			Value: common.MonitoringNamespace,
 		},
 		&cli.StringSliceFlag{
 			Name:     "external-ip",
-			Usage:    "downstream cluster IP adddress",
+			Usage:    "downstream cluster IP address or DNS name, repeat for every address the cluster is reachable on",
 			Required: true,
 		},
 		&cli.BoolFlag{
@@ -2,6 +2,9 @@ package commands
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/rancher/wrangler/pkg/apply"
 	"github.com/rancher/wrangler/pkg/generated/controllers/core"
@@ -14,10 +17,14 @@ import (
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/kubernetes"
 
+	"github.com/goatlas-io/atlas/pkg/clusterstatus"
 	"github.com/goatlas-io/atlas/pkg/common"
 	"github.com/goatlas-io/atlas/pkg/config"
 	"github.com/goatlas-io/atlas/pkg/controllers/atlas"
+	"github.com/goatlas-io/atlas/pkg/crds"
+	atlasv1 "github.com/goatlas-io/atlas/pkg/generated/controllers/atlas.goatlas.io"
 	"github.com/goatlas-io/atlas/pkg/metrics"
+	"github.com/goatlas-io/atlas/pkg/pki"
 )
 
 type controlCommand struct{}
@@ -34,6 +41,60 @@ func (s *controlCommand) Execute(c *cli.Context) error {
 	conf.ADSAddress = c.String("envoy-ads-address")
 	conf.ADSPort = c.Int64("envoy-ads-port")
 	conf.EnvoyAddress = c.String("envoy-address")
+	conf.XDSProtocol = c.String("envoy-xds-protocol")
+	conf.CALifetime = c.Duration("ca-lifetime")
+	conf.CARotateFraction = c.Float64("ca-rotate-fraction")
+	conf.CertLifetime = c.Duration("cert-lifetime")
+	conf.CertRotateFraction = c.Float64("cert-rotate-fraction")
+	conf.RotationInterval = c.Duration("rotation-interval")
+	conf.CARetireGrace = c.Duration("ca-retire-grace")
+	conf.CRLValidity = c.Duration("crl-validity")
+	conf.CASource = c.String("ca-source")
+	conf.ExternalCASecret = c.String("ca-secret")
+	conf.CAIssuerName = c.String("ca-issuer")
+	conf.CAIssuerKind = c.String("ca-issuer-kind")
+	conf.CertSource = c.String("cert-source")
+	conf.CertIssuerName = c.String("cert-issuer")
+	conf.CertIssuerKind = c.String("cert-issuer-kind")
+	conf.KeyAlgorithm = c.String("key-algorithm")
+	conf.CAKeyAlgorithm = c.String("ca-key-algorithm")
+	conf.SubjectTemplate = c.String("cert-subject")
+
+	// Note: leaf certificates are served by envoy, which rules out ed25519. The CA only signs them.
+	if !pki.IsLeafKeyAlgorithm(conf.KeyAlgorithm) {
+		return fmt.Errorf("unsupported key algorithm %q, valid options are: %s", conf.KeyAlgorithm, strings.Join(pki.LeafKeyAlgorithms, ", "))
+	}
+	if conf.CAKeyAlgorithm == "" {
+		conf.CAKeyAlgorithm = conf.KeyAlgorithm
+	}
+	if !pki.IsKeyAlgorithm(conf.CAKeyAlgorithm) {
+		return fmt.Errorf("unsupported ca key algorithm %q, valid options are: %s", conf.CAKeyAlgorithm, strings.Join(pki.KeyAlgorithms, ", "))
+	}
+	if _, err := pki.Subject(conf.SubjectTemplate, common.ServerCommonName); err != nil {
+		return err
+	}
+
+	switch conf.CASource {
+	case config.CASourceSelfSigned:
+	case config.CASourceSecret:
+		if conf.ExternalCASecret == "" {
+			return fmt.Errorf("--ca-secret is required when the ca source is %s", conf.CASource)
+		}
+	case config.CASourceCertManager:
+		if conf.CAIssuerName == "" {
+			return fmt.Errorf("--ca-issuer is required when the ca source is %s", conf.CASource)
+		}
+	default:
+		return fmt.Errorf("invalid ca source %q, valid options are: self-signed, secret, cert-manager", conf.CASource)
+	}
+
+	if conf.CertSource != config.CertSourceAtlas && conf.CertSource != config.CertSourceCertManager {
+		return fmt.Errorf("invalid cert source %q, valid options are: atlas, cert-manager", conf.CertSource)
+	}
+
+	if conf.XDSProtocol != config.XDSProtocolSotW && conf.XDSProtocol != config.XDSProtocolDelta {
+		return fmt.Errorf("invalid xds protocol %q, valid options are: sotw, delta", conf.XDSProtocol)
+	}
 
 	cfg, err := kubeconfig.GetNonInteractiveClientConfig(c.String("kubeconfig")).ClientConfig()
 	if err != nil {
@@ -55,14 +116,28 @@ func (s *controlCommand) Execute(c *cli.Context) error {
 		return err
 	}
 
-	atlas, err := atlas.Register(ctx, conf, log, c, apply, core.Core().V1().Secret(), core.Core().V1().ConfigMap(), core.Core().V1().Service())
+	atlasv1, err := atlasv1.NewFactoryFromConfig(cfg)
 	if err != nil {
 		return err
 	}
 
+	recorder := clusterstatus.NewRecorder(kube, atlasv1.Atlas().V1alpha1().AtlasCluster(), "atlas-controller")
+
+	atlas, err := atlas.Register(ctx, conf, log, c, apply,
+		core.Core().V1().Secret(),
+		core.Core().V1().ConfigMap(),
+		core.Core().V1().Service(),
+		atlasv1.Atlas().V1alpha1().AtlasCluster(),
+		recorder)
+	if err != nil {
+		return err
+	}
+
+	// Become leader, then create CRDS (or update), followed by starting all controllers
 	leader.RunOrDie(ctx, c.String("namespace"), c.String("lockname"), kube, func(ctx context.Context) {
+		runtime.Must(crds.Create(ctx, cfg))
 		runtime.Must(atlas.Setup())
-		runtime.Must(start.All(ctx, 50, core))
+		runtime.Must(start.All(ctx, 50, core, atlasv1))
 
 		<-ctx.Done()
 	})
@@ -106,9 +181,15 @@ func init() {
 		},
 		&cli.Int64Flag{
 			Name:    "envoy-ads-port",
-			Usage:   "The port for Atlas' Aggreggated Discovery Service (ADS) Server",
+			Usage:   "The port Envoy proxies reach Atlas' Aggreggated Discovery Service (ADS) Server on, typically through its ingress",
 			EnvVars: []string{"ATLAS_ENVOY_ADS_PORT"},
-			Value:   10900,
+			Value:   443,
+		},
+		&cli.StringFlag{
+			Name:    "envoy-xds-protocol",
+			Usage:   "The xDS protocol Envoy proxies are bootstrapped with (sotw, delta)",
+			EnvVars: []string{"ATLAS_ENVOY_XDS_PROTOCOL"},
+			Value:   config.XDSProtocolSotW,
 		},
 		&cli.StringFlag{
 			Name:    "dns-config-map-name",
@@ -116,6 +197,104 @@ func init() {
 			EnvVars: []string{"ATLAS_DNS_CM_NAME"},
 			Value:   common.DNSConfigMapName,
 		},
+		&cli.DurationFlag{
+			Name:    "ca-lifetime",
+			Usage:   "How long a newly generated certificate authority is valid for",
+			EnvVars: []string{"ATLAS_CA_LIFETIME"},
+			Value:   10 * 365 * 24 * time.Hour,
+		},
+		&cli.Float64Flag{
+			Name:    "ca-rotate-fraction",
+			Usage:   "Fraction of the certificate authority lifetime after which it is rotated (0 disables)",
+			EnvVars: []string{"ATLAS_CA_ROTATE_FRACTION"},
+			Value:   0.8,
+		},
+		&cli.DurationFlag{
+			Name:    "cert-lifetime",
+			Usage:   "How long a newly issued leaf certificate is valid for",
+			EnvVars: []string{"ATLAS_CERT_LIFETIME"},
+			Value:   365 * 24 * time.Hour,
+		},
+		&cli.Float64Flag{
+			Name:    "cert-rotate-fraction",
+			Usage:   "Fraction of a leaf certificate lifetime after which it is reissued (0 disables)",
+			EnvVars: []string{"ATLAS_CERT_ROTATE_FRACTION"},
+			Value:   0.66,
+		},
+		&cli.DurationFlag{
+			Name:    "rotation-interval",
+			Usage:   "How often certificates are checked for rotation (0 disables)",
+			EnvVars: []string{"ATLAS_ROTATION_INTERVAL"},
+			Value:   time.Hour,
+		},
+		&cli.DurationFlag{
+			Name:    "ca-retire-grace",
+			Usage:   "How long an expired or retired certificate authority is kept in the trust bundle",
+			EnvVars: []string{"ATLAS_CA_RETIRE_GRACE"},
+			Value:   24 * time.Hour,
+		},
+		&cli.DurationFlag{
+			Name:    "crl-validity",
+			Usage:   "How long a published certificate revocation list is valid for, it is refreshed halfway through",
+			EnvVars: []string{"ATLAS_CRL_VALIDITY"},
+			Value:   7 * 24 * time.Hour,
+		},
+		&cli.StringFlag{
+			Name:    "ca-source",
+			Usage:   "Where the certificate authority comes from (self-signed, secret, cert-manager)",
+			EnvVars: []string{"ATLAS_CA_SOURCE"},
+			Value:   config.CASourceSelfSigned,
+		},
+		&cli.StringFlag{
+			Name:    "ca-secret",
+			Usage:   "Name of the TLS secret holding an external certificate authority keypair (ca source secret)",
+			EnvVars: []string{"ATLAS_CA_SECRET"},
+		},
+		&cli.StringFlag{
+			Name:    "ca-issuer",
+			Usage:   "Name of the cert-manager issuer that issues the intermediate certificate authority (ca source cert-manager)",
+			EnvVars: []string{"ATLAS_CA_ISSUER"},
+		},
+		&cli.StringFlag{
+			Name:    "ca-issuer-kind",
+			Usage:   "Kind of the cert-manager issuer for the certificate authority (Issuer, ClusterIssuer)",
+			EnvVars: []string{"ATLAS_CA_ISSUER_KIND"},
+			Value:   "ClusterIssuer",
+		},
+		&cli.StringFlag{
+			Name:    "cert-source",
+			Usage:   "Who issues leaf certificates (atlas, cert-manager)",
+			EnvVars: []string{"ATLAS_CERT_SOURCE"},
+			Value:   config.CertSourceAtlas,
+		},
+		&cli.StringFlag{
+			Name:    "cert-issuer",
+			Usage:   "Name of the cert-manager issuer for leaf certificates, defaults to an issuer backed by the Atlas CA",
+			EnvVars: []string{"ATLAS_CERT_ISSUER"},
+		},
+		&cli.StringFlag{
+			Name:    "cert-issuer-kind",
+			Usage:   "Kind of the cert-manager issuer for leaf certificates (Issuer, ClusterIssuer)",
+			EnvVars: []string{"ATLAS_CERT_ISSUER_KIND"},
+			Value:   "ClusterIssuer",
+		},
+		&cli.StringFlag{
+			Name:    "key-algorithm",
+			Usage:   "Key algorithm for the leaf certificates (rsa-2048, rsa-3072, rsa-4096, ecdsa-p256, ecdsa-p384)",
+			EnvVars: []string{"ATLAS_KEY_ALGORITHM"},
+			Value:   pki.KeyAlgorithmRSA4096,
+		},
+		&cli.StringFlag{
+			Name:    "ca-key-algorithm",
+			Usage:   "Key algorithm for the CA (rsa-2048, rsa-3072, rsa-4096, ecdsa-p256, ecdsa-p384, ed25519), defaults to --key-algorithm",
+			EnvVars: []string{"ATLAS_CA_KEY_ALGORITHM"},
+		},
+		&cli.StringFlag{
+			Name:    "cert-subject",
+			Usage:   "Subject template for certificates, attributes are comma separated and {{ .CommonName }} is available",
+			EnvVars: []string{"ATLAS_CERT_SUBJECT"},
+			Value:   pki.DefaultSubjectTemplate,
+		},
 	}
 
 	cliCmd := &cli.Command{
@@ -3,6 +3,7 @@ package commands
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/rancher/wrangler/pkg/apply"
 	"github.com/rancher/wrangler/pkg/generated/controllers/core"
@@ -15,9 +16,11 @@ import (
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/kubernetes"
 
+	"github.com/goatlas-io/atlas/pkg/clusterstatus"
 	"github.com/goatlas-io/atlas/pkg/common"
 	"github.com/goatlas-io/atlas/pkg/config"
 	"github.com/goatlas-io/atlas/pkg/envoy"
+	atlasv1 "github.com/goatlas-io/atlas/pkg/generated/controllers/atlas.goatlas.io"
 	"github.com/goatlas-io/atlas/pkg/metrics"
 )
 
@@ -29,10 +32,12 @@ func (s *envoyADSCommand) Execute(c *cli.Context) error {
 
 	log := logrus.WithField("command", "envoy-ads")
 
-	go metrics.NewMetricsServer(ctx, c.String("metrics-port"), true, metrics.EnvoyAdsRegistry)
-
 	conf := config.NewEnvoyADSConfig()
 	conf.AtlasEnvoyAddress = c.String("envoy-address")
+	conf.GRPCAddress = c.String("grpc-address")
+	conf.GRPCTLSPort = c.Int("grpc-tls-port")
+	conf.SyncWindow = c.Duration("sync-window")
+	conf.HealthDiscovery = c.Bool("health-discovery")
 
 	cfg, err := kubeconfig.GetNonInteractiveClientConfig(c.String("kubeconfig")).ClientConfig()
 	if err != nil {
@@ -54,13 +59,24 @@ func (s *envoyADSCommand) Execute(c *cli.Context) error {
 		return err
 	}
 
+	atlasv1, err := atlasv1.NewFactoryFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	recorder := clusterstatus.NewRecorder(kube, atlasv1.Atlas().V1alpha1().AtlasCluster(), "atlas-envoy-ads")
+
 	envoyads := envoy.Register(ctx, conf, log, apply, c,
 		core.Core().V1().Service(),
-		core.Core().V1().Secret())
+		core.Core().V1().Secret(),
+		atlasv1.Atlas().V1alpha1().AtlasCluster(),
+		recorder)
+
+	go metrics.NewMetricsServer(ctx, c.String("metrics-port"), true, metrics.EnvoyAdsRegistry, envoyads.DebugHandlers()...)
 
 	// Become leader, then create CRDS (or update), followed by starting all controllers
 	leader.RunOrDie(ctx, c.String("namespace"), c.String("lockname"), kube, func(ctx context.Context) {
-		runtime.Must(start.All(ctx, 50, core))
+		runtime.Must(start.All(ctx, 50, core, atlasv1))
 		runtime.Must(envoyads.Start(ctx, c.Int("grpc-port"), c.Int64("node-id"), c.Bool("debug-envoy")))
 
 		<-ctx.Done()
@@ -85,6 +101,30 @@ func init() {
 			EnvVars: []string{"GRPC_PORT", "ENVOY_ADS_GRPC_PORT", "ATLAS_ENVOY_ADS_GRPC_PORT"},
 			Value:   6305,
 		},
+		&cli.StringFlag{
+			Name:    "grpc-address",
+			Usage:   "Address the plaintext GRPC Interface listens on, it serves private keys without authentication so only bind it to other interfaces when the mTLS port is disabled",
+			EnvVars: []string{"GRPC_ADDRESS", "ENVOY_ADS_GRPC_ADDRESS", "ATLAS_ENVOY_ADS_GRPC_ADDRESS"},
+			Value:   "127.0.0.1",
+		},
+		&cli.IntFlag{
+			Name:    "grpc-tls-port",
+			Usage:   "Port for the mTLS GRPC Interface of the Management Server, verifies node ids against client certificates (0 disables)",
+			EnvVars: []string{"GRPC_TLS_PORT", "ENVOY_ADS_GRPC_TLS_PORT", "ATLAS_ENVOY_ADS_GRPC_TLS_PORT"},
+			Value:   6307,
+		},
+		&cli.DurationFlag{
+			Name:    "sync-window",
+			Usage:   "How long change events are collected before the snapshots are regenerated once for all of them (0 syncs right away)",
+			EnvVars: []string{"ENVOY_ADS_SYNC_WINDOW", "ATLAS_ENVOY_ADS_SYNC_WINDOW"},
+			Value:   time.Second,
+		},
+		&cli.BoolFlag{
+			Name:    "health-discovery",
+			Usage:   "Health check downstream clusters with static addresses through the health discovery service (HDS) and export the results, their envoy clusters then take the endpoint health from EDS instead of checking it themselves",
+			EnvVars: []string{"ATLAS_ENVOY_ADS_HEALTH_DISCOVERY"},
+			Value:   true,
+		},
 		&cli.IntFlag{
 			Name:    "metrics-port",
 			Usage:   "Port for the metrics and debug http server to listen on",
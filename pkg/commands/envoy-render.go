@@ -0,0 +1,111 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/goatlas-io/atlas/pkg/common"
+	"github.com/goatlas-io/atlas/pkg/config"
+	"github.com/goatlas-io/atlas/pkg/envoy"
+)
+
+type envoyRenderCommand struct{}
+
+func (s *envoyRenderCommand) Execute(c *cli.Context) error {
+	log := logrus.WithField("command", "envoy-render")
+
+	objects, err := envoy.ReadManifests(c.StringSlice("filename")...)
+	if err != nil {
+		return err
+	}
+
+	conf := config.NewEnvoyADSConfig()
+	conf.AtlasEnvoyAddress = c.String("envoy-address")
+	conf.HealthDiscovery = c.Bool("health-discovery")
+
+	views, err := envoy.Render(conf, log, c, objects, c.String("snapshot-version"), !c.Bool("show-private-keys"))
+	if err != nil {
+		return err
+	}
+
+	nodes := map[string]bool{}
+	for _, node := range c.StringSlice("node") {
+		nodes[node] = true
+	}
+
+	for _, view := range views {
+		if len(nodes) > 0 && !nodes[view.Node] {
+			continue
+		}
+
+		data, err := envoy.MarshalView(view, true)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("---\n%s", data)
+	}
+
+	return nil
+}
+
+func init() {
+	cmd := envoyRenderCommand{}
+
+	flags := []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:     "filename",
+			Aliases:  []string{"f"},
+			Usage:    "Manifest or directory of manifests with the services, secrets and atlas clusters to render from (- reads stdin)",
+			Required: true,
+		},
+		&cli.StringSliceFlag{
+			Name:  "node",
+			Usage: "Only print the snapshots of these node ids",
+		},
+		&cli.StringFlag{
+			Name:  "snapshot-version",
+			Usage: "Version of the rendered snapshots, fixed so the output can be diffed",
+			Value: "v.0",
+		},
+		&cli.BoolFlag{
+			Name:  "show-private-keys",
+			Usage: "Print the private keys of secrets instead of redacting them",
+		},
+		&cli.BoolFlag{
+			Name:    "health-discovery",
+			Usage:   "Render the snapshots the way the Envoy ADS server does with the health discovery service (HDS) enabled",
+			EnvVars: []string{"ATLAS_ENVOY_ADS_HEALTH_DISCOVERY"},
+			Value:   true,
+		},
+		&cli.StringFlag{
+			Name:    "envoy-address",
+			Usage:   "FQDN or IP of Atlas' Envoy Server",
+			EnvVars: []string{"ATLAS_ENVOY_ADDRESS"},
+			Value:   "localhost",
+		},
+		&cli.StringFlag{
+			Name:    "alertmanager-selector",
+			Usage:   "Label Selector for AlertManager",
+			EnvVars: []string{"ATLAS_ALERTMANAGER_SELECTOR"},
+			Value:   common.ObservabilityAlertManagerServiceLabel,
+		},
+		&cli.StringFlag{
+			Name:  "namespace",
+			Usage: "namespace where atlas resources are located, used for manifests without a namespace",
+			Value: common.MonitoringNamespace,
+		},
+	}
+
+	cliCmd := &cli.Command{
+		Name:   "envoy-render",
+		Usage:  "Render the Envoy ADS snapshots of every node from manifests without cluster access",
+		Action: cmd.Execute,
+		Flags:  append(flags, globalFlags()...),
+		Before: globalBefore,
+	}
+
+	common.RegisterCommand(cliCmd)
+}
@@ -0,0 +1,26 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IsReservedClusterName returns true for the names that identify the envoy ads server nodes themselves,
+// a downstream cluster named after them would be issued a certificate for the observability node
+func IsReservedClusterName(name string) bool {
+	return name == EnvoyADSObservabilityID || name == EnvoyADSClusterID
+}
+
+// ClusterNodeID returns the envoy node id of the downstream cluster, its snapshot is keyed by it
+func ClusterNodeID(name string) string {
+	return fmt.Sprintf(ClusterNodeIDFormat, name)
+}
+
+// ClusterFromNodeID returns the name of the downstream cluster the envoy node id belongs to
+func ClusterFromNodeID(nodeID string) (string, bool) {
+	prefix := ClusterNodeID("")
+	if !strings.HasPrefix(nodeID, prefix) || len(nodeID) == len(prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(nodeID, prefix), true
+}
@@ -8,7 +8,17 @@ const (
 	ClientSecretName     = "atlas-client"
 	IngressTLSSecretName = "atlas-tls"
 
+	// ServerCommonName and ClientCommonName identify the observability cluster envoy
+	ServerCommonName = "server.atlas"
+	ClientCommonName = "client.atlas"
+
+	// ClusterSecretNameFormat is the name of the secret holding the certificate of a downstream cluster
+	ClusterSecretNameFormat = "%s-atlas-cert"
+	// ClusterCommonNameFormat is the common name and SAN of the certificate of a downstream cluster
+	ClusterCommonNameFormat = "%s.cluster.atlas"
+
 	CAOwnerID          = "atlas-ca"
+	ClusterCertOwnerID = "atlas-cluster-cert"
 	CARotateAnnotation = "goatlas.io/ca-rotate"
 	CARevisionLabel    = "goatlas.io/ca-revision"
 	CASerialLabel      = "goatlas.io/ca-serial"
@@ -16,9 +26,38 @@ const (
 	CAChecksumLabel    = "goatlas.io/ca-checksum"
 	CAUsageClientLabel = "goatlas.io/ca-usage-client"
 	CAUsageServerLabel = "goatlas.io/ca-usage-server"
+	CAClusterLabel     = "goatlas.io/ca-cluster"
 	IsCALabel          = "goatlas.io/ca"
 	IsCertLabel        = "goatlas.io/cert"
 
+	// These are used when the certificate authority or leaf certificates come from cert-manager
+	ExternalCASecretName = "atlas-ca-external"
+	CACertificateName    = "atlas-ca"
+	CACommonName         = "ca.atlas"
+	CAIssuerName         = "atlas-ca"
+	CAIssuerSecretName   = "atlas-ca-issuer"
+	CAIssuerKey          = "ca-issuer.pem"
+	CACertificateOwnerID = "atlas-ca-certificate"
+	CAIssuerOwnerID      = "atlas-ca-issuer"
+
+	// IssuanceLogConfigMapName is the ConfigMap recording every certificate minted by Atlas, keyed by serial
+	IssuanceLogConfigMapName = "atlas-issuance-log"
+
+	// CARevokedAnnotation holds the revoked certificate serials along with when they were revoked, their expiry
+	// and the serial of their issuing CA as JSON, setting RevokeAnnotation on a certificate secret revokes the
+	// certificate in it and issues a new one
+	CARevokedAnnotation = "goatlas.io/ca-revoked"
+	RevokeAnnotation    = "goatlas.io/revoke"
+	CRLSecretName       = "atlas-crl"
+	CRLOwnerID          = "atlas-crl"
+	IsCRLLabel          = "goatlas.io/crl"
+
+	CRLChecksumAnnotation = "goatlas.io/crl-checksum"
+	CRLRefreshAnnotation  = "goatlas.io/crl-refresh"
+
+	// CARetiredAnnotation holds the serials of retired CAs and when they were retired as JSON
+	CARetiredAnnotation = "goatlas.io/ca-retired"
+
 	AtlasClusterLabel = "goatlas.io/cluster"
 	SidecarLabel      = "goatlas.io/thanos-sidecar"
 	ReplicasLabel     = "goatlas.io/replicas"
@@ -45,8 +84,10 @@ const (
 	ClusterInboundThanosPort       = 11901 // This is the port envoy listens to on the downstream cluster for connections to thanos
 	ClusterInboundPrometheusPort   = 11904 // This is the port envoy listens to on the downstream cluster for connections to prometheus
 	ClusterInboundAlertManagerPort = 11903 // This is the port envoy listens to on the downstream cluster for connections to alertmanager
+	ClusterExposedAlertManagerPort = 11905 // This is the port envoy listens to on the downstream cluster for connections from the observability cluster to its alertmanager
+	ClusterInboundServicesPort     = 11906 // This is the port envoy listens to on the downstream cluster for connections to exposed http and grpc services
+	ClusterInboundTCPServicesPort  = 11907 // This is the port envoy listens to on the downstream cluster for connections to exposed tcp services, routed by SNI
 
-	ObservabilityADSPort          = 10900
 	ObservabilityThanosPort       = 10901
 	ObservabilityPrometheusPort   = 10904
 	ObservabilityAlertManagerPort = 10903
@@ -62,4 +103,6 @@ const (
 
 	EnvoyADSObservabilityID = "atlas"
 	EnvoyADSClusterID       = "cluster"
+	// ClusterNodeIDFormat is the envoy node id of a downstream cluster, the prefix keeps it apart from the observability node
+	ClusterNodeIDFormat = EnvoyADSClusterID + ".%s"
 )
@@ -1,19 +1,88 @@
 package config
 
+import (
+	"time"
+
+	"github.com/goatlas-io/atlas/pkg/pki"
+)
+
+const (
+	// CASourceSelfSigned has Atlas generate and rotate its own certificate authority
+	CASourceSelfSigned = "self-signed"
+	// CASourceSecret imports an externally managed certificate authority from a TLS secret
+	CASourceSecret = "secret"
+	// CASourceCertManager requests an intermediate certificate authority from a cert-manager issuer
+	CASourceCertManager = "cert-manager"
+
+	// CertSourceAtlas has Atlas sign leaf certificates itself
+	CertSourceAtlas = "atlas"
+	// CertSourceCertManager requests leaf certificates through cert-manager Certificate resources
+	CertSourceCertManager = "cert-manager"
+
+	// XDSProtocolSotW has envoy use the state of the world xDS protocol, every response carries all resources of a type
+	XDSProtocolSotW = "sotw"
+	// XDSProtocolDelta has envoy use the incremental xDS protocol, responses only carry changed and removed resources
+	XDSProtocolDelta = "delta"
+)
+
 type ControllerConfig struct {
 	ADSAddress   string
 	ADSPort      int64
 	EnvoyAddress string
+	XDSProtocol  string
+
+	CALifetime         time.Duration
+	CARotateFraction   float64
+	CertLifetime       time.Duration
+	CertRotateFraction float64
+	RotationInterval   time.Duration
+	CARetireGrace      time.Duration
+	CRLValidity        time.Duration
+
+	CASource         string
+	ExternalCASecret string
+	CAIssuerName     string
+	CAIssuerKind     string
+	CertSource       string
+	CertIssuerName   string
+	CertIssuerKind   string
+
+	KeyAlgorithm    string
+	CAKeyAlgorithm  string
+	SubjectTemplate string
 }
 
 func NewControllerConfig() *ControllerConfig {
-	return &ControllerConfig{}
+	return &ControllerConfig{
+		XDSProtocol:        XDSProtocolSotW,
+		CALifetime:         10 * 365 * 24 * time.Hour,
+		CARotateFraction:   0.8,
+		CertLifetime:       365 * 24 * time.Hour,
+		CertRotateFraction: 0.66,
+		RotationInterval:   time.Hour,
+		CARetireGrace:      24 * time.Hour,
+		CRLValidity:        7 * 24 * time.Hour,
+		CASource:           CASourceSelfSigned,
+		CertSource:         CertSourceAtlas,
+		KeyAlgorithm:       pki.KeyAlgorithmRSA4096,
+		CAKeyAlgorithm:     pki.KeyAlgorithmRSA4096,
+		SubjectTemplate:    pki.DefaultSubjectTemplate,
+	}
 }
 
 type EnvoyADSConfig struct {
 	AtlasEnvoyAddress string
+	GRPCAddress       string
+	GRPCTLSPort       int
+	SyncWindow        time.Duration
+	// HealthDiscovery assigns the health checks of downstream clusters with static addresses to the
+	// observability envoy through HDS instead of configuring them on its clusters
+	HealthDiscovery bool
 }
 
 func NewEnvoyADSConfig() *EnvoyADSConfig {
-	return &EnvoyADSConfig{}
+	return &EnvoyADSConfig{
+		SyncWindow:      time.Second,
+		HealthDiscovery: true,
+	}
 }
@@ -0,0 +1,68 @@
+package atlas
+
+import (
+	"crypto/x509"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/goatlas-io/atlas/pkg/common"
+	"github.com/goatlas-io/atlas/pkg/pki"
+)
+
+// recordIssuance adds the certificate to the issuance log ConfigMap, keyed by serial, so that every
+// valid certificate Atlas has minted can be audited. Entries of expired certificates are pruned on every
+// write so the ConfigMap stays well below its size limit.
+func (c *Controller) recordIssuance(cert *x509.Certificate, issuerSerial string) error {
+	record, err := pki.NewIssuanceRecord(cert, issuerSerial).Encode()
+	if err != nil {
+		return err
+	}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cm, err := c.configmaps.Get(c.namespace, common.IssuanceLogConfigMapName, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			_, err = c.configmaps.Create(&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      common.IssuanceLogConfigMapName,
+					Namespace: c.namespace,
+				},
+				Data: map[string]string{
+					cert.SerialNumber.String(): record,
+				},
+			})
+			if apierrors.IsAlreadyExists(err) {
+				return apierrors.NewConflict(corev1.Resource("configmaps"), common.IssuanceLogConfigMapName, err)
+			}
+			return err
+		} else if err != nil {
+			return err
+		}
+
+		cm = cm.DeepCopy()
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		pruneIssuanceLog(cm.Data, time.Now())
+		cm.Data[cert.SerialNumber.String()] = record
+
+		_, err = c.configmaps.Update(cm)
+		return err
+	})
+}
+
+// pruneIssuanceLog removes the records of expired certificates, records that cannot be parsed are kept
+func pruneIssuanceLog(data map[string]string, now time.Time) {
+	for serial, v := range data {
+		record, err := pki.DecodeIssuanceRecord(v)
+		if err != nil {
+			continue
+		}
+		if record.Expired(now) {
+			delete(data, serial)
+		}
+	}
+}
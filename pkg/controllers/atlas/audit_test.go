@@ -0,0 +1,38 @@
+package atlas
+
+import (
+	"testing"
+	"time"
+
+	"github.com/goatlas-io/atlas/pkg/pki"
+)
+
+func TestPruneIssuanceLog(t *testing.T) {
+	now := time.Now()
+
+	encode := func(serial string, notAfter time.Time) string {
+		record, err := pki.IssuanceRecord{Serial: serial, NotAfter: notAfter}.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return record
+	}
+
+	data := map[string]string{
+		"1": encode("1", now.Add(-time.Hour)),
+		"2": encode("2", now.Add(time.Hour)),
+		"3": "not json",
+	}
+
+	pruneIssuanceLog(data, now)
+
+	if _, ok := data["1"]; ok {
+		t.Error("expected the record of the expired certificate to be pruned")
+	}
+	if _, ok := data["2"]; !ok {
+		t.Error("expected the record of the valid certificate to be kept")
+	}
+	if _, ok := data["3"]; !ok {
+		t.Error("expected the unparsable record to be kept")
+	}
+}
@@ -0,0 +1,307 @@
+package atlas
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/goatlas-io/atlas/pkg/apis/atlas.goatlas.io/v1alpha1"
+	"github.com/goatlas-io/atlas/pkg/common"
+	"github.com/goatlas-io/atlas/pkg/config"
+)
+
+// handleServiceChange converts legacy services labeled as atlas clusters into AtlasCluster resources,
+// this allows existing clusters to keep working while migrating to the custom resource.
+func (c *Controller) handleServiceChange(key string, service *corev1.Service) (*corev1.Service, error) {
+	if service == nil {
+		return nil, nil
+	}
+
+	labels := service.GetLabels()
+	if _, ok := labels[common.AtlasClusterLabel]; !ok {
+		return service, nil
+	}
+
+	if common.IsReservedClusterName(service.Name) {
+		logrus.WithField("service", key).Warnf("cluster name %q is reserved, not converting service", service.Name)
+		return service, nil
+	}
+
+	cluster := clusterFromService(service)
+
+	if err := c.apply.WithCacheTypes(c.atlasClusters).WithSetOwnerReference(true, false).WithOwner(service).ApplyObjects(cluster); err != nil {
+		logrus.WithError(err).Error("unable to convert service to atlas cluster")
+		return service, err
+	}
+
+	return service, nil
+}
+
+func (c *Controller) handleAtlasClusterChange(key string, cluster *v1alpha1.AtlasCluster) (*v1alpha1.AtlasCluster, error) {
+	if cluster == nil {
+		return nil, nil
+	}
+
+	// Note: a cluster named after a node of the envoy ads server would be issued a certificate for that node
+	if common.IsReservedClusterName(cluster.Name) {
+		err := fmt.Errorf("cluster name %q is reserved", cluster.Name)
+		_ = c.recorder.SetCondition(cluster.Namespace, cluster.Name, v1alpha1.ClusterConditionProvisioned, "ReservedName", err)
+		return cluster, nil
+	}
+
+	var selectors map[string]string
+	if len(cluster.Spec.EnvoySelectors) > 0 {
+		selectors = cluster.Spec.EnvoySelectors
+	} else {
+		selectors = parseSelectors(common.EnvoySelectors)
+	}
+
+	replicas := 1
+	if cluster.Spec.Replicas > 0 {
+		replicas = cluster.Spec.Replicas
+	}
+
+	objs := []runtime.Object{}
+
+	for i := 0; i < replicas; i++ {
+		service := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("%s-thanos-sidecar%d", cluster.Name, i),
+				Namespace: cluster.GetNamespace(),
+				Labels: map[string]string{
+					common.SidecarLabel: fmt.Sprintf("%d", i),
+				},
+			},
+			Spec: corev1.ServiceSpec{
+				ClusterIP: "None",
+				Ports:     sidecarPorts(),
+				Type:      corev1.ServiceTypeClusterIP,
+				Selector:  selectors,
+			},
+		}
+
+		objs = append(objs, service)
+	}
+
+	certSecret, err := c.ensureClusterCertificate(cluster)
+	if errors.Is(err, errCertificatePending) {
+		_ = c.recorder.SetCondition(cluster.Namespace, cluster.Name, v1alpha1.ClusterConditionPKIIssued, "CertificatePending", err)
+		c.atlasClusters.EnqueueAfter(cluster.Namespace, cluster.Name, 10*time.Second)
+		return cluster, nil
+	} else if err != nil {
+		_ = c.recorder.SetCondition(cluster.Namespace, cluster.Name, v1alpha1.ClusterConditionPKIIssued, "CertificateFailed", err)
+		return cluster, err
+	}
+
+	s, err := c.generateEnvoyValuesSecret(cluster, certSecret)
+	if err != nil {
+		_ = c.recorder.SetCondition(cluster.Namespace, cluster.Name, v1alpha1.ClusterConditionPKIIssued, "ValuesSecretFailed", err)
+		return cluster, err
+	}
+	objs = append(objs, s)
+
+	if err := c.apply.WithCacheTypes(c.secrets, c.services).WithSetOwnerReference(false, false).WithOwner(cluster).ApplyObjects(objs...); err != nil {
+		logrus.WithError(err).Error("unable to create thanos-sidecar service")
+		_ = c.recorder.SetCondition(cluster.Namespace, cluster.Name, v1alpha1.ClusterConditionProvisioned, "ApplyFailed", err)
+		return cluster, err
+	}
+
+	if err := c.recorder.SetCondition(cluster.Namespace, cluster.Name, v1alpha1.ClusterConditionProvisioned, "Applied", nil); err != nil {
+		return cluster, err
+	}
+
+	serial := certificateSerial(certSecret)
+	if err := c.recorder.Update(cluster.Namespace, cluster.Name, func(status *v1alpha1.AtlasClusterStatus) {
+		status.CertSerial = serial
+	}); err != nil {
+		return cluster, err
+	}
+
+	if err := c.recorder.SetCondition(cluster.Namespace, cluster.Name, v1alpha1.ClusterConditionPKIIssued, "Issued", nil); err != nil {
+		return cluster, err
+	}
+
+	return cluster, nil
+}
+
+// ensureClusterCertificate issues the certificate that identifies the downstream cluster, it is used by
+// the downstream envoy both as server certificate for its listeners and as client certificate for connections
+// to the observability cluster. It is re-issued when missing, when it was not signed by the current CA or when
+// its key algorithm or subject changed.
+func (c *Controller) ensureClusterCertificate(cluster *v1alpha1.AtlasCluster) (*corev1.Secret, error) {
+	ca := c.authority()
+	if ca == nil {
+		return nil, fmt.Errorf("certificate authority is not configured yet")
+	}
+
+	if c.config.CertSource == config.CertSourceCertManager {
+		return c.requestClusterCertificate(ca, cluster)
+	}
+
+	secretName := fmt.Sprintf(common.ClusterSecretNameFormat, cluster.Name)
+	extKeyUsage := []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	commonName := fmt.Sprintf(common.ClusterCommonNameFormat, cluster.Name)
+
+	_, checksum, err := c.certificateTemplate(ca, extKeyUsage, commonName)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := c.secretsCache.Get(c.namespace, secretName)
+	replaced := err == nil
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	} else if err == nil {
+		l := secret.GetLabels()
+		if l[common.CASignedSerial] == ca.serial && l[common.CAChecksumLabel] == checksum && !c.certRotationDue(secret) && !ca.isRevoked(secret) {
+			return secret, nil
+		}
+	}
+
+	serial, cert, key, _, err := c.generateCert(ca, extKeyUsage, commonName)
+	if err != nil {
+		return nil, err
+	}
+
+	certificatesIssued.WithLabelValues(certKindCluster).Inc()
+	if replaced {
+		certificateRotations.WithLabelValues(certKindCluster).Inc()
+	}
+
+	secret = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: c.namespace,
+			Labels: map[string]string{
+				common.IsCertLabel:        "true",
+				common.CASerialLabel:      fmt.Sprintf("%d", serial),
+				common.CAUsageClientLabel: "true",
+				common.CAUsageServerLabel: "true",
+				common.CAClusterLabel:     cluster.Name,
+				common.CASignedSerial:     ca.serial,
+				common.CAChecksumLabel:    checksum,
+			},
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			"ca.crt":  ca.pem,
+			"tls.crt": cert.Bytes(),
+			"tls.key": key.Bytes(),
+		},
+	}
+
+	if err := c.apply.WithCacheTypes(c.secrets).WithSetID(common.ClusterCertOwnerID).WithOwner(cluster).ApplyObjects(secret); err != nil {
+		return nil, err
+	}
+
+	c.log.WithField("cluster", cluster.Name).WithField("serial", serial).Info("issued cluster certificate")
+
+	return secret, nil
+}
+
+// clusterFromService builds an AtlasCluster from the labels and annotations of a legacy cluster service
+func clusterFromService(service *corev1.Service) *v1alpha1.AtlasCluster {
+	labels := service.GetLabels()
+	annotations := service.GetAnnotations()
+
+	replicas := 1
+	if v, ok := labels[common.ReplicasLabel]; ok {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			logrus.WithError(err).Error("unable to convert string to int")
+		} else {
+			replicas = i
+		}
+	}
+
+	spec := v1alpha1.AtlasClusterSpec{
+		ExternalAddresses: service.Spec.ExternalIPs,
+		Replicas:          replicas,
+		Thanos:            endpointFromAnnotations(annotations, common.ThanosServiceAnnotation, common.ThanosServicePortAnnotation),
+		Prometheus:        endpointFromAnnotations(annotations, common.PrometheusServiceAnnotation, common.PrometheusServicePortAnnotation),
+		AlertManager:      endpointFromAnnotations(annotations, common.AlertManagerServiceAnnotation, common.AlertManagerServicePortAnnotation),
+	}
+
+	if v, ok := annotations[common.EnvoySelectorsAnnotation]; ok {
+		spec.EnvoySelectors = parseSelectors(v)
+	}
+
+	return &v1alpha1.AtlasCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      service.Name,
+			Namespace: service.Namespace,
+		},
+		Spec: spec,
+	}
+}
+
+func endpointFromAnnotations(annotations map[string]string, serviceAnnotation, portAnnotation string) *v1alpha1.ServiceEndpoint {
+	endpoint := &v1alpha1.ServiceEndpoint{}
+
+	if v, ok := annotations[serviceAnnotation]; ok {
+		endpoint.Service = v
+	}
+
+	if v, ok := annotations[portAnnotation]; ok {
+		p, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			logrus.WithError(err).WithField("annotation", portAnnotation).Error("unable to parse port")
+		} else {
+			endpoint.Port = uint32(p)
+		}
+	}
+
+	if endpoint.Service == "" && endpoint.Port == 0 {
+		return nil
+	}
+
+	return endpoint
+}
+
+func parseSelectors(selectors string) map[string]string {
+	resolved := map[string]string{}
+	for _, p := range strings.Split(selectors, ",") {
+		s := strings.SplitN(p, "=", 2)
+		if len(s) != 2 {
+			continue
+		}
+		resolved[s[0]] = s[1]
+	}
+	return resolved
+}
+
+// sidecarPorts are the ports exposed by the thanos sidecar services, these match
+// the ports that `atlas cluster-add` historically created on cluster services.
+func sidecarPorts() []corev1.ServicePort {
+	return []corev1.ServicePort{
+		{
+			Port:       common.PrometheusPort,
+			TargetPort: intstr.FromInt(common.PrometheusPort),
+			Protocol:   corev1.ProtocolTCP,
+			Name:       "prometheus",
+		},
+		{
+			Port:       common.ThanosPort,
+			TargetPort: intstr.FromInt(common.ThanosPort),
+			Protocol:   corev1.ProtocolTCP,
+			Name:       "thanos",
+		},
+		{
+			Port:       common.AlertManagerPort,
+			TargetPort: intstr.FromInt(common.AlertManagerPort),
+			Protocol:   corev1.ProtocolTCP,
+			Name:       "alertmanager",
+		},
+	}
+}
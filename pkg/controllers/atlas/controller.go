@@ -3,16 +3,17 @@ package atlas
 import (
 	"bytes"
 	"context"
+	"crypto"
 	"crypto/md5"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
-	"crypto/x509/pkix"
 	"embed"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
+	"net"
 	"sort"
 	"strconv"
 	"strings"
@@ -32,14 +33,19 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/wait"
 
 	"github.com/rancher/wrangler/pkg/apply"
 	core "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
 	"github.com/rancher/wrangler/pkg/relatedresource"
 
+	"github.com/goatlas-io/atlas/pkg/apis/atlas.goatlas.io/v1alpha1"
+	"github.com/goatlas-io/atlas/pkg/clusterstatus"
 	"github.com/goatlas-io/atlas/pkg/common"
 	"github.com/goatlas-io/atlas/pkg/config"
 	"github.com/goatlas-io/atlas/pkg/envoy"
+	atlascontrollers "github.com/goatlas-io/atlas/pkg/generated/controllers/atlas.goatlas.io/v1alpha1"
+	"github.com/goatlas-io/atlas/pkg/pki"
 )
 
 //go:embed templates/*
@@ -58,11 +64,16 @@ type Controller struct {
 	services      core.ServiceController
 	servicesCache core.ServiceCache
 
-	caPEM    []byte
-	caCrt    *x509.Certificate
-	caKey    *rsa.PrivateKey
-	caSerial string
-	caSecret *corev1.Secret
+	atlasClusters      atlascontrollers.AtlasClusterController
+	atlasClustersCache atlascontrollers.AtlasClusterCache
+
+	recorder *clusterstatus.Recorder
+
+	ca     *authority
+	caLock sync.RWMutex
+
+	pkiLock     sync.Mutex
+	metricsLock sync.Mutex
 
 	dnsUpdateLock sync.Mutex
 	dnsLastHash   string
@@ -79,6 +90,8 @@ func Register(
 	secrets core.SecretController,
 	configmaps core.ConfigMapController,
 	services core.ServiceController,
+	atlasClusters atlascontrollers.AtlasClusterController,
+	recorder *clusterstatus.Recorder,
 ) (*Controller, error) {
 	c := Controller{
 		ctx:           ctx,
@@ -92,23 +105,20 @@ func Register(
 		services:      services,
 		servicesCache: services.Cache(),
 		namespace:     cli.String("namespace"),
+
+		atlasClusters:      atlasClusters,
+		atlasClustersCache: atlasClusters.Cache(),
+
+		recorder: recorder,
 	}
 
 	c.secrets.OnChange(ctx, common.NAME, c.handleSecretChange)
 	c.services.OnChange(ctx, common.NAME, c.handleServiceChange)
 	c.services.OnChange(ctx, common.NAME, c.handleServiceChangeforDNS)
+	c.atlasClusters.OnChange(ctx, common.NAME, c.handleAtlasClusterChange)
 
-	// Index all services that are Atlas Clusters into the PKI index.
-	c.servicesCache.AddIndexer("atlasClusters", func(obj *corev1.Service) ([]string, error) {
-		labels := obj.GetLabels()
-		if _, ok := labels[common.AtlasClusterLabel]; ok {
-			return []string{"pki"}, nil
-		}
-		return []string{}, nil
-	})
-
-	// Watch for changes to Secrets that are marked as Atlas PKI, then enqueue all services that are
-	// Atlas clusters so that they'll reprocess and update envoy values for bootstrap with new PKI
+	// Watch for changes to Secrets that are marked as Atlas PKI, then enqueue all Atlas clusters
+	// so that they'll reprocess and update envoy values for bootstrap with new PKI
 	relatedresource.Watch(ctx, "atlas", func(namespace, name string, obj runtime.Object) (result []relatedresource.Key, _ error) {
 		if obj == nil {
 			return result, nil
@@ -118,27 +128,27 @@ func Register(
 		}
 
 		secret := obj.(*corev1.Secret)
-		labels := secret.GetLabels()
-		_, caOK := labels[common.IsCALabel]
-		_, certOK := labels[common.IsCertLabel]
+		secretLabels := secret.GetLabels()
+		_, caOK := secretLabels[common.IsCALabel]
+		_, certOK := secretLabels[common.IsCertLabel]
 		if !caOK && !certOK {
 			return result, nil
 		}
 
-		services, err := c.servicesCache.GetByIndex("atlasClusters", "pki")
+		clusters, err := c.atlasClustersCache.List(c.namespace, labels.Everything())
 		if err != nil {
 			return nil, err
 		}
 
-		for _, service := range services {
+		for _, cluster := range clusters {
 			result = append(result, relatedresource.Key{
-				Namespace: service.Namespace,
-				Name:      service.Name,
+				Namespace: cluster.Namespace,
+				Name:      cluster.Name,
 			})
 		}
 
 		return result, nil
-	}, c.services, c.secrets)
+	}, c.atlasClusters, c.secrets)
 
 	return &c, nil
 }
@@ -148,10 +158,12 @@ func (c *Controller) createObservabilityValues() error {
 		ClusterID       string
 		EnvoyADSAddress string
 		EnvoyADSPort    int64
+		ADSAPIType      string
 	}{
-		ClusterID:       "atlas",
+		ClusterID:       common.EnvoyADSObservabilityID,
 		EnvoyADSAddress: c.config.ADSAddress,
 		EnvoyADSPort:    c.config.ADSPort,
+		ADSAPIType:      c.adsAPIType(),
 	}
 
 	d, err := templates.ReadFile("templates/envoy-atlas.tmpl")
@@ -191,12 +203,28 @@ func (c *Controller) createObservabilityValues() error {
 	return nil
 }
 
+// adsAPIType returns the api type of the ads config in the envoy bootstrap for the configured xDS protocol
+func (c *Controller) adsAPIType() string {
+	if c.config.XDSProtocol == config.XDSProtocolDelta {
+		return "DELTA_GRPC"
+	}
+	return "GRPC"
+}
+
 func (c *Controller) Setup() error {
 	if err := c.createObservabilityValues(); err != nil {
 		return err
 	}
 
-	if err := c.configureCA(); err != nil {
+	// An external certificate authority may not have been issued yet, wait for it
+	if err := wait.PollImmediateUntil(5*time.Second, func() (bool, error) {
+		err := c.configureCA()
+		if errors.Is(err, errExternalCAPending) {
+			c.log.WithError(err).Info("waiting for certificate authority")
+			return false, nil
+		}
+		return err == nil, err
+	}, c.ctx.Done()); err != nil {
 		c.log.WithError(err).Error("unable to setup ca")
 		return err
 	}
@@ -205,15 +233,49 @@ func (c *Controller) Setup() error {
 		c.log.WithError(err).Error("unable to setup pki material")
 		return err
 	}
+
+	if err := c.updateCRL(); err != nil {
+		c.log.WithError(err).Error("unable to publish certificate revocation lists")
+		return err
+	}
+
+	c.updateCertificateMetrics()
+
+	go c.runRotation()
+	go c.runCRLRefresh()
+
 	return nil
 }
 
 func (c *Controller) handleSecretChange(key string, secret *corev1.Secret) (*corev1.Secret, error) {
 	if secret == nil {
+		// A removed certificate must no longer be reported as expiring
+		c.updateCertificateMetrics()
 		return nil, nil
 	}
 
+	if _, ok := secret.GetLabels()[common.IsCertLabel]; ok {
+		defer c.updateCertificateMetrics()
+	} else if _, ok := secret.GetLabels()[common.IsCALabel]; ok {
+		defer c.updateCertificateMetrics()
+	}
+
+	// Changes to an externally managed CA are imported into the Atlas CA secret
+	if c.config.CASource != config.CASourceSelfSigned && secret.GetName() == c.externalCASecretName() {
+		if err := c.configureCA(); err != nil {
+			return secret, err
+		}
+		return secret, nil
+	}
+
 	annotations := secret.GetAnnotations()
+
+	if _, ok := annotations[common.RevokeAnnotation]; ok {
+		if _, ok := secret.GetLabels()[common.IsCertLabel]; ok {
+			return secret, c.revokeSecret(secret)
+		}
+	}
+
 	if v, ok := annotations["objectset.rio.cattle.io/id"]; !ok || (ok && v != common.CAOwnerID) {
 		return secret, nil
 	}
@@ -223,10 +285,23 @@ func (c *Controller) handleSecretChange(key string, secret *corev1.Secret) (*cor
 		if err := c.configureCA(); err != nil {
 			return secret, err
 		}
+
+		if err := c.pruneCAs(); err != nil {
+			return secret, err
+		}
+
+		// Reissue the shared certificates if the CA was rotated or one of them was revoked
+		if err := c.setupPKI(); err != nil {
+			return secret, err
+		}
+
+		if err := c.updateCRL(); err != nil {
+			return secret, err
+		}
 	}
 
 	if _, ok := labels[common.IsCertLabel]; ok {
-		if c.caSecret == nil {
+		if c.authority() == nil {
 			c.secrets.EnqueueAfter(secret.GetNamespace(), secret.GetName(), 5*time.Second)
 			return secret, nil
 		}
@@ -243,6 +318,10 @@ func (c *Controller) configureCA() error {
 
 	log.Debug("start")
 
+	if c.config.CASource != config.CASourceSelfSigned {
+		return c.importCA()
+	}
+
 	revision := 1
 	isNew := false
 	doGenerate := false
@@ -276,13 +355,10 @@ func (c *Controller) configureCA() error {
 			}
 		}
 
-		caCert, err := decodePEM(caSecret.Data["ca.pem"])
-		if err != nil {
+		if _, err := decodePEM(caSecret.Data["ca.pem"]); err != nil {
 			return err
 		}
 
-		c.caSerial = caCert.SerialNumber.String()
-
 		if v, ok := labels[common.CARevisionLabel]; ok {
 			r, _ := strconv.Atoi(v)
 			revision = r + 1
@@ -297,6 +373,11 @@ func (c *Controller) configureCA() error {
 			return err
 		}
 
+		certificatesIssued.WithLabelValues(certKindCA).Inc()
+		if !isNew {
+			certificateRotations.WithLabelValues(certKindCA).Inc()
+		}
+
 		caSecret = &corev1.Secret{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:        common.CASecretName,
@@ -316,18 +397,7 @@ func (c *Controller) configureCA() error {
 		}
 
 		if !isNew {
-			currentCALabels := currentCASecret.GetLabels()
-			serial := currentCALabels[common.CASerialLabel]
-
-			caSecret.Data[fmt.Sprintf("ca-%s.pem", serial)] = currentCASecret.Data["ca.pem"]
-
-			for k, v := range currentCASecret.Data {
-				if k == "ca-key.pem" || k == "ca.pem" {
-					continue
-				}
-
-				caSecret.Data[k] = v
-			}
+			carryPreviousCAs(caSecret, currentCASecret)
 		}
 
 		if err := c.apply.WithCacheTypes(c.secrets).WithSetID(common.CAOwnerID).ApplyObjects(caSecret); err != nil {
@@ -337,260 +407,357 @@ func (c *Controller) configureCA() error {
 		log.Info("generated/rotated certificate authority")
 	}
 
-	if v, ok := caSecret.Data["ca.pem"]; ok {
-		block, _ := pem.Decode([]byte(v))
-		if block == nil {
-			return fmt.Errorf("failed to parse certificate PEM")
-		}
+	if err := c.loadCA(caSecret); err != nil {
+		return err
+	}
+
+	log.Debug("finished")
 
-		cert, err := x509.ParseCertificate(block.Bytes)
+	return nil
+}
+
+// authority is the active certificate authority, it is replaced as a whole whenever the CA secret changes
+// and never modified afterwards so that a certificate is always signed with the key of the CA it names
+type authority struct {
+	pem     []byte
+	crt     *x509.Certificate
+	key     crypto.Signer
+	serial  string
+	secret  *corev1.Secret
+	revoked map[string]time.Time
+}
+
+// authority returns the active certificate authority, nil until the CA has been loaded
+func (c *Controller) authority() *authority {
+	c.caLock.RLock()
+	defer c.caLock.RUnlock()
+
+	return c.ca
+}
+
+// loadCA reads the active certificate authority and its key from the CA secret
+func (c *Controller) loadCA(caSecret *corev1.Secret) error {
+	ca := &authority{secret: caSecret}
+
+	if v, ok := caSecret.Data["ca.pem"]; ok {
+		cert, err := decodePEM(v)
 		if err != nil {
 			return err
 		}
 
-		c.caPEM = v
-		c.caCrt = cert
+		ca.pem = v
+		ca.crt = cert
+		ca.serial = cert.SerialNumber.String()
 	}
 	if v, ok := caSecret.Data["ca-key.pem"]; ok {
-		block, _ := pem.Decode([]byte(v))
-		if block == nil {
-			return fmt.Errorf("failed to parse certificate PEM")
-		}
-
-		parsedKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		parsedKey, err := pki.ParsePrivateKey(v)
 		if err != nil {
 			return err
 		}
 
-		c.caKey = parsedKey
+		ca.key = parsedKey
 	}
 
-	c.caSecret = caSecret
+	revoked, err := pki.Revoked(caSecret)
+	if err != nil {
+		return err
+	}
+	ca.revoked = revoked
 
-	log.Debug("finished")
+	c.caLock.Lock()
+	c.ca = ca
+	c.caLock.Unlock()
+
+	if cas, err := pki.List(caSecret); err == nil {
+		trustedCAs.Set(float64(len(cas)))
+	}
 
 	return nil
 }
 
-func (c *Controller) setupPKI() error {
-	doGenerate := false
+// carryPreviousCAs keeps the certificate authority being replaced, and every previous one that has not
+// been pruned yet, in the new CA secret so they remain trusted while leaf certificates are reissued
+func carryPreviousCAs(caSecret, currentCASecret *corev1.Secret) {
+	serial := currentCASecret.GetLabels()[common.CASerialLabel]
 
-	ingressTLSSecret, err := c.secrets.Get(c.namespace, common.IngressTLSSecretName, metav1.GetOptions{})
-	if err != nil && !apierrors.IsNotFound(err) {
-		return err
-	} else if err != nil && apierrors.IsNotFound(err) {
-		doGenerate = true
-	} else if err == nil {
-		l := ingressTLSSecret.GetLabels()
-		if v, ok := l[common.CASignedSerial]; !ok || (ok && v != c.caSerial) {
-			doGenerate = true
+	for _, annotation := range []string{common.CARetiredAnnotation, common.CARevokedAnnotation} {
+		if v, ok := currentCASecret.GetAnnotations()[annotation]; ok {
+			caSecret.Annotations[annotation] = v
 		}
 	}
 
-	mtlsClientSecret, err := c.secrets.Get(c.namespace, common.ClientSecretName, metav1.GetOptions{})
-	if err != nil && !apierrors.IsNotFound(err) {
-		return err
-	} else if err != nil && apierrors.IsNotFound(err) {
-		doGenerate = true
-	} else if err == nil {
-		l := mtlsClientSecret.GetLabels()
-		if v, ok := l[common.CASignedSerial]; !ok || (ok && v != c.caSerial) {
-			doGenerate = true
-		}
-	}
+	// The key of the previous CA is kept so CRLs can still be signed for the certificates it issued
+	caSecret.Data[fmt.Sprintf("ca-%s.pem", serial)] = currentCASecret.Data["ca.pem"]
+	caSecret.Data[fmt.Sprintf("ca-key-%s.pem", serial)] = currentCASecret.Data["ca-key.pem"]
 
-	mtlsServerSecret, err := c.secrets.Get(c.namespace, common.ServerSecretName, metav1.GetOptions{})
-	if err != nil && !apierrors.IsNotFound(err) {
-		return err
-	} else if err != nil && apierrors.IsNotFound(err) {
-		doGenerate = true
-	} else if err == nil {
-		l := mtlsServerSecret.GetLabels()
-		if v, ok := l[common.CASignedSerial]; !ok || (ok && v != c.caSerial) {
-			doGenerate = true
+	for k, v := range currentCASecret.Data {
+		if _, ok := caSecret.Data[k]; ok || k == "ca-key.pem" || k == "ca.pem" {
+			continue
 		}
+
+		caSecret.Data[k] = v
 	}
+}
 
-	ingressSerial, ingressCert, ingressKey, ingressChecksum, err := c.generateCert([]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, c.config.EnvoyAddress)
-	if err != nil {
-		return err
+// sharedCertificate describes one of the certificates used by the observability cluster
+type sharedCertificate struct {
+	kind        string
+	secretName  string
+	commonName  string
+	extKeyUsage []x509.ExtKeyUsage
+	labels      map[string]string
+}
+
+func (c *Controller) setupPKI() error {
+	c.pkiLock.Lock()
+	defer c.pkiLock.Unlock()
+
+	// Every certificate of this reconcile is issued by the same CA, even if it is rotated meanwhile
+	ca := c.authority()
+	if ca == nil {
+		return fmt.Errorf("certificate authority is not configured yet")
 	}
 
-	serverSerial, serverCert, serverKey, serverChecksum, err := c.generateCert([]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, "server.atlas")
-	if err != nil {
-		return err
+	if c.config.CertSource == config.CertSourceCertManager {
+		return c.requestSharedCertificates(ca)
 	}
 
-	clientSerial, clientCert, clientKey, clientChecksum, err := c.generateCert([]x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, "client.atlas")
-	if err != nil {
-		return err
+	certificates := []sharedCertificate{
+		{
+			kind:        certKindIngress,
+			secretName:  common.IngressTLSSecretName,
+			commonName:  c.config.EnvoyAddress,
+			extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			labels:      map[string]string{},
+		},
+		{
+			kind:        certKindClient,
+			secretName:  common.ClientSecretName,
+			commonName:  common.ClientCommonName,
+			extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			labels:      map[string]string{common.CAUsageClientLabel: "true"},
+		},
+		{
+			kind:        certKindServer,
+			secretName:  common.ServerSecretName,
+			commonName:  common.ServerCommonName,
+			extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			labels:      map[string]string{common.CAUsageServerLabel: "true"},
+		},
 	}
 
-	ingressLabels := ingressTLSSecret.GetLabels()
-	if v, ok := ingressLabels[common.CAChecksumLabel]; !ok || (ok && v != *ingressChecksum) {
-		doGenerate = true
+	// The secrets are applied as one set, so unchanged secrets have to be part of it as well
+	doApply := false
+	secrets := []runtime.Object{}
+
+	for _, certificate := range certificates {
+		secret, issued, err := c.sharedCertificateSecret(ca, certificate)
+		if err != nil {
+			return err
+		}
+
+		doApply = doApply || issued
+		secrets = append(secrets, secret)
 	}
 
-	serverLabels := mtlsServerSecret.GetLabels()
-	if v, ok := serverLabels[common.CAChecksumLabel]; !ok || (ok && v != *serverChecksum) {
-		doGenerate = true
+	if !doApply {
+		return nil
 	}
 
-	clientLabels := mtlsClientSecret.GetLabels()
-	if v, ok := clientLabels[common.CAChecksumLabel]; !ok || (ok && v != *clientChecksum) {
-		doGenerate = true
+	return c.apply.WithCacheTypes(c.secrets).WithOwner(ca.secret).ApplyObjects(secrets...)
+}
+
+// sharedCertificateSecret returns the desired secret for the certificate, a new keypair is only generated
+// when the secret is missing, was signed by another CA, is due for rotation or its settings changed
+func (c *Controller) sharedCertificateSecret(ca *authority, certificate sharedCertificate) (*corev1.Secret, bool, error) {
+	_, checksum, err := c.certificateTemplate(ca, certificate.extKeyUsage, certificate.commonName)
+	if err != nil {
+		return nil, false, err
 	}
 
-	if doGenerate {
-		ingressTLSSecret = &corev1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      common.IngressTLSSecretName,
-				Namespace: c.namespace,
-				Labels: map[string]string{
-					common.IsCertLabel:    "true",
-					common.CASerialLabel:  fmt.Sprintf("%d", ingressSerial),
-					common.CASignedSerial: c.caSerial,
+	existing, err := c.secretsCache.Get(c.namespace, certificate.secretName)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, false, err
+	}
+
+	replaced := err == nil
+	if replaced {
+		l := existing.GetLabels()
+		if l[common.CASignedSerial] == ca.serial && l[common.CAChecksumLabel] == checksum && !c.certRotationDue(existing) && !ca.isRevoked(existing) {
+			// Note: the secret is part of the applied set, apply must not modify the cached object
+			existing = existing.DeepCopy()
+			return &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      existing.Name,
+					Namespace: existing.Namespace,
+					Labels:    existing.Labels,
 				},
-			},
-			Type: corev1.SecretTypeTLS,
-			Data: map[string][]byte{
-				"ca.crt":  c.caPEM,
-				"tls.crt": ingressCert.Bytes(),
-				"tls.key": ingressKey.Bytes(),
-			},
+				Type: existing.Type,
+				Data: existing.Data,
+			}, false, nil
 		}
+	}
 
-		mtlsClientSecret = &corev1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      common.ClientSecretName,
-				Namespace: c.namespace,
-				Labels: map[string]string{
-					common.IsCertLabel:        "true",
-					common.CASerialLabel:      fmt.Sprintf("%d", clientSerial),
-					common.CAUsageClientLabel: "true",
-					common.CASignedSerial:     c.caSerial,
-				},
-			},
-			Type: corev1.SecretTypeTLS,
-			Data: map[string][]byte{
-				"ca.crt":  c.caPEM,
-				"tls.crt": clientCert.Bytes(),
-				"tls.key": clientKey.Bytes(),
-			},
-		}
+	serial, cert, key, _, err := c.generateCert(ca, certificate.extKeyUsage, certificate.commonName)
+	if err != nil {
+		return nil, false, err
+	}
 
-		mtlsServerSecret = &corev1.Secret{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      common.ServerSecretName,
-				Namespace: c.namespace,
-				Labels: map[string]string{
-					common.IsCertLabel:        "true",
-					common.CASerialLabel:      fmt.Sprintf("%d", serverSerial),
-					common.CAUsageServerLabel: "true",
-					common.CASignedSerial:     c.caSerial,
-				},
-			},
-			Type: corev1.SecretTypeTLS,
-			Data: map[string][]byte{
-				"ca.crt":  c.caPEM,
-				"tls.crt": serverCert.Bytes(),
-				"tls.key": serverKey.Bytes(),
-			},
-		}
+	certificatesIssued.WithLabelValues(certificate.kind).Inc()
+	if replaced {
+		certificateRotations.WithLabelValues(certificate.kind).Inc()
+	}
 
-		if err := c.apply.WithCacheTypes(c.secrets).WithOwner(c.caSecret).ApplyObjects(ingressTLSSecret, mtlsClientSecret, mtlsServerSecret); err != nil {
-			return err
-		}
+	labels := map[string]string{
+		common.IsCertLabel:     "true",
+		common.CASerialLabel:   fmt.Sprintf("%d", serial),
+		common.CASignedSerial:  ca.serial,
+		common.CAChecksumLabel: checksum,
+	}
+	for k, v := range certificate.labels {
+		labels[k] = v
 	}
 
-	return nil
+	c.log.WithField("secret", certificate.secretName).WithField("serial", serial).Info("issued certificate")
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      certificate.secretName,
+			Namespace: c.namespace,
+			Labels:    labels,
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			"ca.crt":  ca.pem,
+			"tls.crt": cert.Bytes(),
+			"tls.key": key.Bytes(),
+		},
+	}, true, nil
 }
 
-func (c *Controller) generateCert(extKeyUsage []x509.ExtKeyUsage, commonName string) (*big.Int, *bytes.Buffer, *bytes.Buffer, *string, error) {
-	serial := big.NewInt(time.Now().UTC().Unix())
+// certificateTemplate builds the certificate for the given usages and common name along with a checksum of
+// the settings it was built from. Neither key material nor a serial is generated so it is cheap to call on
+// every reconcile, generateCert assigns the serial when the certificate is signed.
+func (c *Controller) certificateTemplate(ca *authority, extKeyUsage []x509.ExtKeyUsage, commonName string) (*x509.Certificate, string, error) {
+	subject, err := pki.Subject(c.config.SubjectTemplate, commonName)
+	if err != nil {
+		return nil, "", err
+	}
 
-	subject := pkix.Name{
-		Organization:       []string{"goatlas.io"},
-		OrganizationalUnit: []string{"Atlas"},
-		Country:            []string{"US"},
-		Province:           []string{"DC"},
-		Locality:           []string{"Washington"},
-		CommonName:         commonName,
+	// Leaf certificates never outlive the CA that signed them
+	notAfter := time.Now().Add(c.config.CertLifetime)
+	if ca.crt != nil && notAfter.After(ca.crt.NotAfter) {
+		notAfter = ca.crt.NotAfter
 	}
 
 	cert := &x509.Certificate{
-		SerialNumber: serial,
 		Subject:      subject,
-		// IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
-		NotBefore: time.Now(),
-		NotAfter:  time.Now().AddDate(10, 0, 0),
-		// SubjectKeyId: []byte{1, 2, 3, 4, 6},
-		ExtKeyUsage: extKeyUsage,
-		KeyUsage:    x509.KeyUsageDigitalSignature,
+		NotBefore:    time.Now(),
+		NotAfter:     notAfter,
+		ExtKeyUsage:  extKeyUsage,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	if ip := net.ParseIP(commonName); ip != nil {
+		cert.IPAddresses = []net.IP{ip}
+	} else {
+		cert.DNSNames = []string{commonName}
+	}
+
+	hash, err := hashstructure.Hash(map[string]interface{}{
+		"subject":      subject,
+		"extKeyUsage":  extKeyUsage,
+		"dnsNames":     cert.DNSNames,
+		"ipAddresses":  cert.IPAddresses,
+		"keyAlgorithm": c.config.KeyAlgorithm,
+	}, hashstructure.FormatV2, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return cert, fmt.Sprintf("%d", hash), nil
+}
+
+func (c *Controller) generateCert(ca *authority, extKeyUsage []x509.ExtKeyUsage, commonName string) (*big.Int, *bytes.Buffer, *bytes.Buffer, *string, error) {
+	cert, checksum, err := c.certificateTemplate(ca, extKeyUsage, commonName)
+	if err != nil {
+		return nil, nil, nil, nil, err
 	}
 
-	certPrivKey, err := rsa.GenerateKey(rand.Reader, 4096)
+	if cert.SerialNumber, err = pki.NewSerial(); err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	certPrivKey, err := pki.GenerateKey(c.config.KeyAlgorithm)
 	if err != nil {
 		return nil, nil, nil, nil, err
 	}
 
-	certBytes, err := x509.CreateCertificate(rand.Reader, cert, c.caCrt, &certPrivKey.PublicKey, c.caKey)
+	certBytes, err := x509.CreateCertificate(rand.Reader, cert, ca.crt, certPrivKey.Public(), ca.key)
 	if err != nil {
 		return nil, nil, nil, nil, err
 	}
 
+	// Note: the issuance log is an audit aid, failing to write it must not block issuing the certificate
+	if err := c.recordIssuance(cert, ca.serial); err != nil {
+		c.log.WithError(err).WithField("serial", cert.SerialNumber.String()).Warn("unable to record certificate issuance")
+	}
+
 	certPEM := new(bytes.Buffer)
 	pem.Encode(certPEM, &pem.Block{
 		Type:  "CERTIFICATE",
 		Bytes: certBytes,
 	})
 
-	certPrivKeyPEM := new(bytes.Buffer)
-	pem.Encode(certPrivKeyPEM, &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(certPrivKey),
-	})
+	// Include the intermediate when the CA is not self-signed so peers can build the chain
+	if !bytes.Equal(ca.crt.RawIssuer, ca.crt.RawSubject) {
+		certPEM.Write(ca.pem)
+	}
 
-	hash, err := hashstructure.Hash(map[string]interface{}{
-		"subject":     subject,
-		"extKeyUsage": extKeyUsage,
-	}, hashstructure.FormatV2, nil)
+	keyPEM, err := pki.EncodePrivateKey(certPrivKey)
 	if err != nil {
 		return nil, nil, nil, nil, err
 	}
-	hs := fmt.Sprintf("%d", hash)
 
-	return serial, certPEM, certPrivKeyPEM, &hs, nil
+	return cert.SerialNumber, certPEM, bytes.NewBuffer(keyPEM), &checksum, nil
 }
 
 func (c *Controller) generateCA() (*big.Int, *bytes.Buffer, *bytes.Buffer, error) {
+	subject, err := pki.Subject(c.config.SubjectTemplate, "")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	serial, err := pki.NewSerial()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
 	ca := &x509.Certificate{
-		SerialNumber: big.NewInt(time.Now().UTC().Unix()),
-		Subject: pkix.Name{
-			Organization:       []string{"goatlas.io"},
-			OrganizationalUnit: []string{"Atlas"},
-			Country:            []string{"US"},
-			Province:           []string{"DC"},
-			Locality:           []string{"Washington"},
-		},
+		SerialNumber:          serial,
+		Subject:               subject,
 		NotBefore:             time.Now(),
-		NotAfter:              time.Now().AddDate(10, 0, 0),
+		NotAfter:              time.Now().Add(c.config.CALifetime),
 		IsCA:                  true,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 		BasicConstraintsValid: true,
 	}
 
-	caPrivKey, err := rsa.GenerateKey(rand.Reader, 4096)
+	caPrivKey, err := pki.GenerateKey(c.config.CAKeyAlgorithm)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
-	caBytes, err := x509.CreateCertificate(rand.Reader, ca, ca, &caPrivKey.PublicKey, caPrivKey)
+	caBytes, err := x509.CreateCertificate(rand.Reader, ca, ca, caPrivKey.Public(), caPrivKey)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
+	if err := c.recordIssuance(ca, ca.SerialNumber.String()); err != nil {
+		c.log.WithError(err).WithField("serial", ca.SerialNumber.String()).Warn("unable to record certificate issuance")
+	}
+
 	caPEM := new(bytes.Buffer)
 	if err := pem.Encode(caPEM, &pem.Block{
 		Type:  "CERTIFICATE",
@@ -599,15 +766,12 @@ func (c *Controller) generateCA() (*big.Int, *bytes.Buffer, *bytes.Buffer, error
 		return nil, nil, nil, err
 	}
 
-	caPrivKeyPEM := new(bytes.Buffer)
-	if err := pem.Encode(caPrivKeyPEM, &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(caPrivKey),
-	}); err != nil {
+	keyPEM, err := pki.EncodePrivateKey(caPrivKey)
+	if err != nil {
 		return nil, nil, nil, err
 	}
 
-	return ca.SerialNumber, caPEM, caPrivKeyPEM, nil
+	return ca.SerialNumber, caPEM, bytes.NewBuffer(keyPEM), nil
 }
 
 func decodePEM(certPEM []byte) (*x509.Certificate, error) {
@@ -624,75 +788,6 @@ func decodePEM(certPEM []byte) (*x509.Certificate, error) {
 	return cert, nil
 }
 
-func (c *Controller) handleServiceChange(key string, service *corev1.Service) (*corev1.Service, error) {
-	if service == nil {
-		return nil, nil
-	}
-
-	labels := service.GetLabels()
-	if _, ok := labels[common.AtlasClusterLabel]; !ok {
-		return service, nil
-	}
-
-	resolvedSelectors := map[string]string{}
-	defaultSelectors := common.EnvoySelectors
-
-	annotations := service.GetAnnotations()
-	if v, ok := annotations[common.EnvoySelectorsAnnotation]; ok {
-		defaultSelectors = v
-	}
-
-	for _, p := range strings.Split(defaultSelectors, ",") {
-		s := strings.Split(p, "=")
-		resolvedSelectors[s[0]] = s[1]
-	}
-
-	replicas := 1
-	if v, ok := labels[common.ReplicasLabel]; ok {
-		i, err := strconv.Atoi(v)
-		if err != nil {
-			logrus.WithError(err).Error("unable to convert string to int")
-		} else {
-			replicas = i
-		}
-	}
-
-	objs := []runtime.Object{}
-
-	for i := 0; i < replicas; i++ {
-		service := &corev1.Service{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      fmt.Sprintf("%s-thanos-sidecar%d", service.Name, i),
-				Namespace: service.GetNamespace(),
-				Labels: map[string]string{
-					common.SidecarLabel: fmt.Sprintf("%d", i),
-				},
-			},
-			Spec: corev1.ServiceSpec{
-				ClusterIP: "None",
-				Ports:     service.Spec.Ports,
-				Type:      corev1.ServiceTypeClusterIP,
-				Selector:  resolvedSelectors,
-			},
-		}
-
-		objs = append(objs, service)
-	}
-
-	s, err := c.generateEnvoyValuesSecret(service)
-	if err != nil {
-		return service, err
-	}
-	objs = append(objs, s)
-
-	if err := c.apply.WithCacheTypes(c.secrets, c.services).WithSetOwnerReference(false, false).WithOwner(service).ApplyObjects(objs...); err != nil {
-		logrus.WithError(err).Error("unable to create thanos-sidecar service")
-		return service, err
-	}
-
-	return service, nil
-}
-
 func (c *Controller) handleServiceChangeforDNS(key string, service *corev1.Service) (*corev1.Service, error) {
 	if service == nil {
 		return nil, nil
@@ -793,22 +888,12 @@ func (c *Controller) handleServiceChangeforDNS(key string, service *corev1.Servi
 	return service, nil
 }
 
-func (c *Controller) generateEnvoyValuesSecret(service *corev1.Service) (*corev1.Secret, error) {
+func (c *Controller) generateEnvoyValuesSecret(cluster *v1alpha1.AtlasCluster, cert *corev1.Secret) (*corev1.Secret, error) {
 	ca, err := c.secretsCache.Get(c.namespace, common.CASecretName)
 	if err != nil {
 		return nil, err
 	}
 
-	server, err := c.secretsCache.Get(c.namespace, common.ServerSecretName)
-	if err != nil {
-		return nil, err
-	}
-
-	client, err := c.secretsCache.Get(c.namespace, common.ClientSecretName)
-	if err != nil {
-		return nil, err
-	}
-
 	actualAMServices := []*corev1.Service{}
 	amServices, err := c.services.List(c.namespace, v1.ListOptions{
 		LabelSelector: c.cli.String("alertmanager-selector"),
@@ -831,16 +916,18 @@ func (c *Controller) generateEnvoyValuesSecret(service *corev1.Service) (*corev1
 		ClusterID         string
 		EnvoyADSAddress   string
 		EnvoyADSPort      int64
+		ADSAPIType        string
 		AlertmanagerCount int
 	}{
 		CA:                string(envoy.CombineCAs(ca)),
-		ServerCert:        string(server.Data["tls.crt"]),
-		ServerKey:         string(server.Data["tls.key"]),
-		ClientCert:        string(client.Data["tls.crt"]),
-		ClientKey:         string(client.Data["tls.key"]),
-		ClusterID:         service.Name,
+		ServerCert:        string(cert.Data["tls.crt"]),
+		ServerKey:         string(cert.Data["tls.key"]),
+		ClientCert:        string(cert.Data["tls.crt"]),
+		ClientKey:         string(cert.Data["tls.key"]),
+		ClusterID:         common.ClusterNodeID(cluster.Name),
 		EnvoyADSAddress:   c.config.ADSAddress,
 		EnvoyADSPort:      c.config.ADSPort,
+		ADSAPIType:        c.adsAPIType(),
 		AlertmanagerCount: len(actualAMServices),
 	}
 
@@ -863,7 +950,7 @@ func (c *Controller) generateEnvoyValuesSecret(service *corev1.Service) (*corev1
 		return nil, err
 	}
 
-	secretName := fmt.Sprintf("%s-envoy-values", service.Name)
+	secretName := fmt.Sprintf("%s-envoy-values", cluster.Name)
 	s := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      secretName,
@@ -0,0 +1,275 @@
+package atlas
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"testing"
+
+	"github.com/rancher/wrangler/pkg/apply"
+	"github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/goatlas-io/atlas/pkg/common"
+	"github.com/goatlas-io/atlas/pkg/config"
+	"github.com/goatlas-io/atlas/pkg/pki"
+)
+
+// fakeSecretCache serves the secrets applied by fakeApply
+type fakeSecretCache struct {
+	v1.SecretCache
+	secrets map[string]*corev1.Secret
+}
+
+func (f *fakeSecretCache) Get(namespace, name string) (*corev1.Secret, error) {
+	secret, ok := f.secrets[fmt.Sprintf("%s/%s", namespace, name)]
+	if !ok {
+		return nil, apierrors.NewNotFound(corev1.Resource("secrets"), name)
+	}
+	return secret, nil
+}
+
+// applier is embedded under another name, a field named Apply would shadow the Apply method
+type applier = apply.Apply
+
+// fakeApply stores the applied secrets in the secret cache
+type fakeApply struct {
+	applier
+	cache   *fakeSecretCache
+	applies int
+}
+
+func (f *fakeApply) WithCacheTypes(...apply.InformerGetter) apply.Apply { return f }
+
+func (f *fakeApply) WithOwner(runtime.Object) apply.Apply { return f }
+
+func (f *fakeApply) ApplyObjects(objs ...runtime.Object) error {
+	f.applies++
+	for _, obj := range objs {
+		if secret, ok := obj.(*corev1.Secret); ok {
+			f.cache.secrets[fmt.Sprintf("%s/%s", secret.Namespace, secret.Name)] = secret
+		}
+	}
+	return nil
+}
+
+// fakeConfigMaps fails every request, recording issuances is best effort
+type fakeConfigMaps struct {
+	v1.ConfigMapController
+}
+
+func (f *fakeConfigMaps) Get(namespace, name string, options metav1.GetOptions) (*corev1.ConfigMap, error) {
+	return nil, apierrors.NewServiceUnavailable("configmaps are not available")
+}
+
+// newTestController returns a controller with a self-signed CA whose shared certificates are not issued yet
+func newTestController(tb testing.TB) (*Controller, *fakeApply) {
+	tb.Helper()
+
+	conf := config.NewControllerConfig()
+	conf.EnvoyAddress = "envoy.atlas.local"
+	conf.KeyAlgorithm = pki.KeyAlgorithmECDSAP256
+	conf.CAKeyAlgorithm = pki.KeyAlgorithmECDSAP256
+
+	log := logrus.New()
+	log.SetLevel(logrus.ErrorLevel)
+
+	cache := &fakeSecretCache{secrets: map[string]*corev1.Secret{}}
+	fake := &fakeApply{cache: cache}
+
+	c := &Controller{
+		ctx:          context.Background(),
+		config:       conf,
+		log:          logrus.NewEntry(log),
+		apply:        fake,
+		secretsCache: cache,
+		configmaps:   &fakeConfigMaps{},
+		namespace:    "monitoring",
+	}
+
+	if err := c.loadCA(newTestCASecret(tb, c)); err != nil {
+		tb.Fatal(err)
+	}
+
+	return c, fake
+}
+
+// newTestCASecret returns a CA secret with a newly generated self-signed CA
+func newTestCASecret(tb testing.TB, c *Controller) *corev1.Secret {
+	tb.Helper()
+
+	_, caPEM, keyPEM, err := c.generateCA()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: common.CASecretName, Namespace: c.namespace},
+		Data: map[string][]byte{
+			"ca.pem":     caPEM.Bytes(),
+			"ca-key.pem": keyPEM.Bytes(),
+		},
+	}
+}
+
+func TestSetupPKIIssuesOnce(t *testing.T) {
+	c, applier := newTestController(t)
+
+	if err := c.setupPKI(); err != nil {
+		t.Fatal(err)
+	}
+	if applier.applies != 1 {
+		t.Fatalf("expected the shared certificates to be applied once, got %d", applier.applies)
+	}
+
+	if err := c.setupPKI(); err != nil {
+		t.Fatal(err)
+	}
+	if applier.applies != 1 {
+		t.Fatalf("expected unchanged certificates not to be applied again, got %d applies", applier.applies)
+	}
+
+	c.config.SubjectTemplate = "O=example.com"
+	if err := c.setupPKI(); err != nil {
+		t.Fatal(err)
+	}
+	if applier.applies != 2 {
+		t.Fatalf("expected a subject change to reissue the certificates, got %d applies", applier.applies)
+	}
+}
+
+func TestCertificateTemplateLeavesTheSerialToSigning(t *testing.T) {
+	c, _ := newTestController(t)
+	ca := c.authority()
+	usage := []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+
+	template, checksum, err := c.certificateTemplate(ca, usage, common.ServerCommonName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if template.SerialNumber != nil {
+		t.Errorf("expected the template to have no serial, got %s", template.SerialNumber)
+	}
+
+	serial, _, _, issued, err := c.generateCert(ca, usage, common.ServerCommonName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial == nil || serial.Sign() <= 0 {
+		t.Errorf("expected the signed certificate to have a positive serial, got %v", serial)
+	}
+	if *issued != checksum {
+		t.Errorf("expected the checksum of the signed certificate %s to match the template %s", *issued, checksum)
+	}
+}
+
+func TestSetupPKIWithAnEd25519CA(t *testing.T) {
+	c, _ := newTestController(t)
+
+	c.config.CAKeyAlgorithm = pki.KeyAlgorithmEd25519
+	if err := c.loadCA(newTestCASecret(t, c)); err != nil {
+		t.Fatal(err)
+	}
+
+	ca := c.authority()
+	if ca.crt.PublicKeyAlgorithm != x509.Ed25519 {
+		t.Fatalf("expected an ed25519 CA, got %s", ca.crt.PublicKeyAlgorithm)
+	}
+
+	if err := c.setupPKI(); err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := c.secretsCache.Get(c.namespace, common.ServerSecretName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := decodePEM(server.Data["tls.crt"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cert.PublicKeyAlgorithm != x509.ECDSA {
+		t.Errorf("expected an ecdsa leaf certificate, got %s", cert.PublicKeyAlgorithm)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.crt)
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}); err != nil {
+		t.Errorf("leaf certificate does not verify against the ed25519 CA: %s", err)
+	}
+}
+
+func TestSetupPKIWhileTheCAIsRotated(t *testing.T) {
+	c, _ := newTestController(t)
+
+	// The secret handler loads rotated CAs while the rotation loop reissues the certificates
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			if err := c.loadCA(newTestCASecret(t, c)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for rotating := true; rotating; {
+		select {
+		case <-done:
+			rotating = false
+		default:
+		}
+
+		if err := c.setupPKI(); err != nil {
+			t.Fatal(err)
+		}
+
+		server, err := c.secretsCache.Get(c.namespace, common.ServerSecretName)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ca, err := decodePEM(server.Data["ca.crt"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := decodePEM(server.Data["tls.crt"])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := cert.CheckSignatureFrom(ca); err != nil {
+			t.Fatalf("certificate is not signed by the CA it is distributed with: %s", err)
+		}
+		if server.Labels[common.CASignedSerial] != ca.SerialNumber.String() {
+			t.Fatalf("certificate is labelled with CA %s but distributed with CA %s", server.Labels[common.CASignedSerial], ca.SerialNumber)
+		}
+	}
+}
+
+// BenchmarkSetupPKIUnchanged measures a reconcile of the shared certificates when none of them has to be reissued
+func BenchmarkSetupPKIUnchanged(b *testing.B) {
+	c, applier := newTestController(b)
+
+	if err := c.setupPKI(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := c.setupPKI(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	if applier.applies != 1 {
+		b.Fatalf("expected unchanged certificates not to be applied again, got %d applies", applier.applies)
+	}
+}
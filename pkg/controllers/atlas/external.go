@@ -0,0 +1,345 @@
+package atlas
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/goatlas-io/atlas/pkg/apis/atlas.goatlas.io/v1alpha1"
+	"github.com/goatlas-io/atlas/pkg/common"
+	"github.com/goatlas-io/atlas/pkg/config"
+	"github.com/goatlas-io/atlas/pkg/pki"
+)
+
+var (
+	errExternalCAPending  = errors.New("external certificate authority is not available yet")
+	errCertificatePending = errors.New("certificate has not been issued by cert-manager yet")
+)
+
+// externalCASecretName returns the name of the TLS secret the certificate authority is imported from
+func (c *Controller) externalCASecretName() string {
+	if c.config.CASource == config.CASourceCertManager {
+		return common.ExternalCASecretName
+	}
+	return c.config.ExternalCASecret
+}
+
+// importCA copies an externally managed certificate authority into the Atlas CA secret. When the
+// external CA changes the previous one is kept in the trust bundle just like a rotation.
+func (c *Controller) importCA() error {
+	if c.config.CASource == config.CASourceCertManager {
+		if err := c.requestCACertificate(); err != nil {
+			return err
+		}
+	}
+
+	sourceName := c.externalCASecretName()
+
+	source, err := c.secrets.Get(c.namespace, sourceName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return fmt.Errorf("%w: secret %s not found", errExternalCAPending, sourceName)
+	} else if err != nil {
+		return err
+	}
+
+	crt, ok := source.Data[corev1.TLSCertKey]
+	if !ok || len(crt) == 0 {
+		return fmt.Errorf("%w: secret %s has no %s", errExternalCAPending, sourceName, corev1.TLSCertKey)
+	}
+
+	cert, err := decodePEM(crt)
+	if err != nil {
+		return err
+	}
+	if !cert.IsCA {
+		return fmt.Errorf("certificate in secret %s is not a certificate authority", sourceName)
+	}
+
+	key := source.Data[corev1.TLSPrivateKeyKey]
+	if _, err := pki.ParsePrivateKey(key); err != nil {
+		return err
+	}
+
+	revision := 1
+	caSecret, err := c.secrets.Get(c.namespace, common.CASecretName, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	isNew := apierrors.IsNotFound(err)
+
+	if !isNew {
+		if _, ok := caSecret.GetAnnotations()[common.CARotateAnnotation]; ok {
+			c.log.Warn("ignoring rotation request, the certificate authority is managed externally")
+
+			caSecret = caSecret.DeepCopy()
+			delete(caSecret.Annotations, common.CARotateAnnotation)
+			if caSecret, err = c.secrets.Update(caSecret); err != nil {
+				return err
+			}
+		}
+
+		current, err := decodePEM(caSecret.Data["ca.pem"])
+		if err == nil && current.SerialNumber.Cmp(cert.SerialNumber) == 0 &&
+			bytes.Equal(caSecret.Data[common.CAIssuerKey], source.Data["ca.crt"]) {
+			return c.loadCA(caSecret)
+		}
+
+		if v, ok := caSecret.GetLabels()[common.CARevisionLabel]; ok {
+			r, _ := strconv.Atoi(v)
+			revision = r + 1
+		}
+	}
+
+	newCASecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        common.CASecretName,
+			Namespace:   c.namespace,
+			Annotations: map[string]string{},
+			Labels: map[string]string{
+				common.IsCALabel:       "true",
+				common.CARevisionLabel: strconv.Itoa(revision),
+				common.CASerialLabel:   cert.SerialNumber.String(),
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"ca.pem":     crt,
+			"ca-key.pem": key,
+		},
+	}
+
+	// The issuer of an intermediate has to be trusted as well for envoy to verify the chain
+	if v, ok := source.Data["ca.crt"]; ok && len(v) > 0 {
+		newCASecret.Data[common.CAIssuerKey] = v
+	}
+
+	if !isNew {
+		carryPreviousCAs(newCASecret, caSecret)
+	}
+
+	if err := c.apply.WithCacheTypes(c.secrets).WithSetID(common.CAOwnerID).ApplyObjects(newCASecret); err != nil {
+		return err
+	}
+
+	c.log.WithField("source", sourceName).WithField("serial", cert.SerialNumber.String()).Info("imported external certificate authority")
+
+	return c.loadCA(newCASecret)
+}
+
+// requestCACertificate requests the intermediate certificate authority from the configured cert-manager issuer
+func (c *Controller) requestCACertificate() error {
+	if c.config.CAIssuerName == "" {
+		return fmt.Errorf("a cert-manager issuer is required for the certificate authority")
+	}
+
+	subject, err := pki.Subject(c.config.SubjectTemplate, common.CACommonName)
+	if err != nil {
+		return err
+	}
+
+	certificate := pki.NewCertificate(pki.CertificateSpec{
+		Name:       common.CACertificateName,
+		Namespace:  c.namespace,
+		SecretName: common.ExternalCASecretName,
+		CommonName: common.CACommonName,
+		IsCA:       true,
+		Usages:     []string{"cert sign", "crl sign", "digital signature"},
+		Issuer: pki.IssuerRef{
+			Name: c.config.CAIssuerName,
+			Kind: c.config.CAIssuerKind,
+		},
+		Duration:     c.config.CALifetime,
+		RenewBefore:  renewBefore(c.config.CALifetime, c.config.CARotateFraction),
+		Subject:      subject,
+		KeyAlgorithm: c.config.CAKeyAlgorithm,
+	})
+
+	return c.apply.WithSetID(common.CACertificateOwnerID).ApplyObjects(certificate)
+}
+
+// leafIssuer returns the cert-manager issuer for leaf certificates, unless one is configured
+// an Issuer backed by the Atlas CA is managed so that leafs chain to the Atlas trust bundle
+func (c *Controller) leafIssuer(ca *authority) (pki.IssuerRef, error) {
+	if c.config.CertIssuerName != "" {
+		return pki.IssuerRef{Name: c.config.CertIssuerName, Kind: c.config.CertIssuerKind}, nil
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      common.CAIssuerSecretName,
+			Namespace: c.namespace,
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       ca.pem,
+			corev1.TLSPrivateKeyKey: ca.secret.Data["ca-key.pem"],
+		},
+	}
+
+	issuer := pki.NewCAIssuer(common.CAIssuerName, c.namespace, common.CAIssuerSecretName)
+
+	if err := c.apply.WithCacheTypes(c.secrets).WithSetID(common.CAIssuerOwnerID).WithOwner(ca.secret).ApplyObjects(secret, issuer); err != nil {
+		return pki.IssuerRef{}, err
+	}
+
+	return pki.IssuerRef{Name: common.CAIssuerName, Kind: "Issuer"}, nil
+}
+
+// requestSharedCertificates requests the ingress, server and client certificates of the observability
+// cluster through cert-manager, it is the cert-manager counterpart of setupPKI
+func (c *Controller) requestSharedCertificates(ca *authority) error {
+	issuer, err := c.leafIssuer(ca)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{common.IngressTLSSecretName, common.ClientSecretName, common.ServerSecretName} {
+		secret, err := c.secretsCache.Get(c.namespace, name)
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if _, err := c.deleteRevokedSecret(ca, secret); err != nil {
+			return err
+		}
+	}
+
+	ingress, err := c.leafCertificate(common.IngressTLSSecretName, c.config.EnvoyAddress, issuer, []string{"server auth"}, map[string]string{
+		common.IsCertLabel: "true",
+	})
+	if err != nil {
+		return err
+	}
+
+	client, err := c.leafCertificate(common.ClientSecretName, common.ClientCommonName, issuer, []string{"client auth"}, map[string]string{
+		common.IsCertLabel:        "true",
+		common.CAUsageClientLabel: "true",
+	})
+	if err != nil {
+		return err
+	}
+
+	server, err := c.leafCertificate(common.ServerSecretName, common.ServerCommonName, issuer, []string{"server auth"}, map[string]string{
+		common.IsCertLabel:        "true",
+		common.CAUsageServerLabel: "true",
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.apply.WithOwner(ca.secret).ApplyObjects(ingress, client, server)
+}
+
+// requestClusterCertificate requests the certificate of a downstream cluster through cert-manager and
+// returns the secret once cert-manager has issued it
+func (c *Controller) requestClusterCertificate(ca *authority, cluster *v1alpha1.AtlasCluster) (*corev1.Secret, error) {
+	issuer, err := c.leafIssuer(ca)
+	if err != nil {
+		return nil, err
+	}
+
+	secretName := fmt.Sprintf(common.ClusterSecretNameFormat, cluster.Name)
+
+	certificate, err := c.leafCertificate(secretName, fmt.Sprintf(common.ClusterCommonNameFormat, cluster.Name), issuer,
+		[]string{"server auth", "client auth"}, map[string]string{
+			common.IsCertLabel:        "true",
+			common.CAUsageClientLabel: "true",
+			common.CAUsageServerLabel: "true",
+			common.CAClusterLabel:     cluster.Name,
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.apply.WithSetID(common.ClusterCertOwnerID).WithOwner(cluster).ApplyObjects(certificate); err != nil {
+		return nil, err
+	}
+
+	secret, err := c.secrets.Get(c.namespace, secretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, errCertificatePending
+	} else if err != nil {
+		return nil, err
+	}
+
+	if len(secret.Data[corev1.TLSCertKey]) == 0 || len(secret.Data[corev1.TLSPrivateKeyKey]) == 0 {
+		return nil, errCertificatePending
+	}
+
+	if deleted, err := c.deleteRevokedSecret(ca, secret); err != nil {
+		return nil, err
+	} else if deleted {
+		return nil, errCertificatePending
+	}
+
+	return secret, nil
+}
+
+// deleteRevokedSecret removes a secret issued by cert-manager once its certificate was revoked, cert-manager
+// then issues a new certificate into it. It returns true when the secret was removed.
+func (c *Controller) deleteRevokedSecret(ca *authority, secret *corev1.Secret) (bool, error) {
+	if !ca.isRevoked(secret) {
+		return false, nil
+	}
+
+	if err := c.secrets.Delete(secret.Namespace, secret.Name, &metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+		return false, err
+	}
+
+	c.log.WithField("secret", secret.Name).WithField("serial", certificateSerial(secret)).Info("removed revoked certificate, cert-manager issues a new one")
+
+	return true, nil
+}
+
+func (c *Controller) leafCertificate(secretName, commonName string, issuer pki.IssuerRef, usages []string, labels map[string]string) (*unstructured.Unstructured, error) {
+	subject, err := pki.Subject(c.config.SubjectTemplate, commonName)
+	if err != nil {
+		return nil, err
+	}
+
+	return pki.NewCertificate(pki.CertificateSpec{
+		Name:         secretName,
+		Namespace:    c.namespace,
+		SecretName:   secretName,
+		CommonName:   commonName,
+		Usages:       append([]string{"digital signature", "key encipherment"}, usages...),
+		Issuer:       issuer,
+		Duration:     c.config.CertLifetime,
+		RenewBefore:  renewBefore(c.config.CertLifetime, c.config.CertRotateFraction),
+		Subject:      subject,
+		KeyAlgorithm: c.config.KeyAlgorithm,
+		SecretLabels: labels,
+	}), nil
+}
+
+// certificateSerial returns the serial of the certificate in a TLS secret
+func certificateSerial(secret *corev1.Secret) string {
+	if v, ok := secret.GetLabels()[common.CASerialLabel]; ok {
+		return v
+	}
+
+	cert, err := decodePEM(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		return ""
+	}
+
+	return cert.SerialNumber.String()
+}
+
+// renewBefore converts a rotation fraction into the cert-manager renewBefore duration
+func renewBefore(lifetime time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 || fraction >= 1 {
+		return 0
+	}
+
+	return time.Duration(float64(lifetime) * (1 - fraction))
+}
@@ -0,0 +1,51 @@
+package atlas
+
+import (
+	"github.com/goatlas-io/atlas/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Kinds of certificates used as the kind label of the certificate metrics
+const (
+	certKindCA      = "ca"
+	certKindIngress = "ingress"
+	certKindClient  = "client"
+	certKindServer  = "server"
+	certKindCluster = "cluster"
+)
+
+var (
+	trustedCAs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "atlas_controller_trusted_cas",
+		Help: "The number of certificate authorities in the Atlas trust bundle",
+	})
+	certificateNotAfter = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "atlas_controller_certificate_not_after_timestamp_seconds",
+		Help: "The unix timestamp at which the active certificate authority or a leaf certificate expires",
+	}, []string{"kind", "secret", "cluster"})
+	certificatesIssued = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "atlas_controller_certificates_issued_total",
+		Help: "The number of certificates issued by the Atlas certificate authority, including reissued ones",
+	}, []string{"kind"})
+	certificateRotations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "atlas_controller_certificate_rotations_total",
+		Help: "The number of existing certificates that were replaced by a newly issued one",
+	}, []string{"kind"})
+	revokedCertificates = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "atlas_controller_revoked_certificates",
+		Help: "The number of revoked certificates recorded on the CA secret",
+	})
+	crlUncoveredCAs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "atlas_controller_crl_uncovered_cas",
+		Help: "The number of trusted certificate authorities a CRL cannot be signed for, no CRL is published while it is above zero",
+	})
+)
+
+func init() {
+	metrics.AtlasRegistry.MustRegister(trustedCAs)
+	metrics.AtlasRegistry.MustRegister(certificateNotAfter)
+	metrics.AtlasRegistry.MustRegister(certificatesIssued)
+	metrics.AtlasRegistry.MustRegister(certificateRotations)
+	metrics.AtlasRegistry.MustRegister(revokedCertificates)
+	metrics.AtlasRegistry.MustRegister(crlUncoveredCAs)
+}
@@ -0,0 +1,189 @@
+package atlas
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mitchellh/hashstructure/v2"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/goatlas-io/atlas/pkg/common"
+	"github.com/goatlas-io/atlas/pkg/pki"
+)
+
+// isRevoked returns true if the certificate in the secret has been revoked
+func (a *authority) isRevoked(secret *corev1.Secret) bool {
+	_, ok := a.revoked[certificateSerial(secret)]
+	return ok
+}
+
+// revokeSecret revokes the certificate in a secret carrying the revoke annotation, the annotation is
+// removed afterwards and the certificate is reissued by the regular reconcile
+func (c *Controller) revokeSecret(secret *corev1.Secret) error {
+	serial := certificateSerial(secret)
+	if serial == "" {
+		return fmt.Errorf("unable to determine the serial of the certificate in secret %s", secret.Name)
+	}
+
+	caSecret, err := c.secrets.Get(c.namespace, common.CASecretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	// Note: the expiry and issuer of the certificate allow pruning the revocation once it no longer matters
+	revocation := pki.Revocation{RevokedAt: time.Now()}
+	if cert, err := decodePEM(secret.Data[corev1.TLSCertKey]); err == nil && cert.SerialNumber.String() == serial {
+		revocation = pki.CertificateRevocation(caSecret, cert, revocation.RevokedAt)
+	}
+
+	caSecret = caSecret.DeepCopy()
+	if err := pki.Revoke(caSecret, serial, revocation); err != nil {
+		return err
+	}
+
+	if _, err := c.secrets.Update(caSecret); err != nil {
+		return err
+	}
+
+	secret = secret.DeepCopy()
+	delete(secret.Annotations, common.RevokeAnnotation)
+	if _, err := c.secrets.Update(secret); err != nil {
+		return err
+	}
+
+	c.log.WithField("secret", secret.Name).WithField("serial", serial).Info("revoked certificate")
+
+	return nil
+}
+
+// runCRLRefresh keeps the published CRLs from expiring independent of certificate rotation, which may be
+// disabled. updateCRL only re-signs them once half of their validity has passed. It blocks until the
+// context is done.
+func (c *Controller) runCRLRefresh() {
+	// Note: checking every quarter of the validity re-signs the CRLs well before they expire
+	interval := c.config.CRLValidity / 4
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.updateCRL(); err != nil {
+				c.log.WithError(err).Error("unable to refresh certificate revocation lists")
+			}
+		}
+	}
+}
+
+// updateCRL publishes the CRLs of the trust bundle in the CRL secret that the envoy ads server adds to the
+// validation context. Without revoked certificates the secret is removed so envoy does not check revocation.
+func (c *Controller) updateCRL() error {
+	caSecret, err := c.secrets.Get(c.namespace, common.CASecretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	revoked, err := pki.Revoked(caSecret)
+	if err != nil {
+		return err
+	}
+
+	// Envoy fails verification of every leaf whose issuer has no CRL once any CRL is configured
+	uncovered, err := pki.CRLUncovered(caSecret)
+	if err != nil {
+		return err
+	}
+
+	revokedCertificates.Set(float64(len(revoked)))
+	crlUncoveredCAs.Set(float64(len(uncovered)))
+
+	if len(revoked) == 0 {
+		return c.apply.WithCacheTypes(c.secrets).WithSetID(common.CRLOwnerID).ApplyObjects()
+	}
+
+	if len(uncovered) > 0 {
+		c.log.WithField("cas", uncovered).Error("unable to sign a CRL for every trusted CA, revocations are not enforced by envoy until these CAs are rotated or retired")
+		return c.apply.WithCacheTypes(c.secrets).WithSetID(common.CRLOwnerID).ApplyObjects()
+	}
+
+	cas, err := pki.List(caSecret)
+	if err != nil {
+		return err
+	}
+
+	caSerials := []string{}
+	for _, ca := range cas {
+		caSerials = append(caSerials, ca.Serial)
+	}
+	sort.Strings(caSerials)
+
+	revokedSerials := []string{}
+	for serial := range revoked {
+		revokedSerials = append(revokedSerials, serial)
+	}
+	sort.Strings(revokedSerials)
+
+	hash, err := hashstructure.Hash(map[string]interface{}{
+		"revoked": revokedSerials,
+		"cas":     caSerials,
+	}, hashstructure.FormatV2, nil)
+	if err != nil {
+		return err
+	}
+	checksum := fmt.Sprintf("%d", hash)
+
+	now := time.Now()
+
+	// CRLs are refreshed halfway through their validity so envoy never sees an expired one
+	existing, err := c.secrets.Get(c.namespace, common.CRLSecretName, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	} else if err == nil {
+		annotations := existing.GetAnnotations()
+		refresh, _ := time.Parse(time.RFC3339, annotations[common.CRLRefreshAnnotation])
+		if annotations[common.CRLChecksumAnnotation] == checksum && now.Before(refresh) {
+			return nil
+		}
+	}
+
+	crls, err := pki.BuildCRLs(caSecret, now, c.config.CRLValidity)
+	if err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      common.CRLSecretName,
+			Namespace: c.namespace,
+			Labels: map[string]string{
+				common.IsCRLLabel: "true",
+			},
+			Annotations: map[string]string{
+				common.CRLChecksumAnnotation: checksum,
+				common.CRLRefreshAnnotation:  now.Add(c.config.CRLValidity / 2).UTC().Format(time.RFC3339),
+			},
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"crl.pem": crls,
+		},
+	}
+
+	if err := c.apply.WithCacheTypes(c.secrets).WithSetID(common.CRLOwnerID).ApplyObjects(secret); err != nil {
+		return err
+	}
+
+	c.log.WithField("revoked", len(revoked)).Info("published certificate revocation lists")
+
+	return nil
+}
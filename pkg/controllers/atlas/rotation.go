@@ -0,0 +1,263 @@
+package atlas
+
+import (
+	"crypto/x509"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/goatlas-io/atlas/pkg/common"
+	"github.com/goatlas-io/atlas/pkg/config"
+	"github.com/goatlas-io/atlas/pkg/pki"
+)
+
+// runRotation periodically checks the CA and leaf certificates and rotates any that have
+// used up their configured fraction of their lifetime. It blocks until the context is done.
+func (c *Controller) runRotation() {
+	if c.config.RotationInterval <= 0 {
+		c.log.Info("certificate rotation is disabled")
+		return
+	}
+
+	ticker := time.NewTicker(c.config.RotationInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.rotate(); err != nil {
+				c.log.WithError(err).Error("unable to rotate certificates")
+			}
+			c.updateCertificateMetrics()
+		}
+	}
+}
+
+func (c *Controller) rotate() error {
+	// Externally managed certificate authorities are rotated by their owner
+	selfSigned := c.config.CASource == config.CASourceSelfSigned
+
+	ca := c.authority()
+	if selfSigned && ca != nil && ca.crt != nil && (rotationDue(ca.crt, c.config.CARotateFraction) || ca.crlRotationDue()) {
+		c.log.WithField("serial", ca.serial).WithField("expires", ca.crt.NotAfter).Info("certificate authority is due for rotation")
+
+		// Rotation goes through the same annotation an operator would set by hand, the previous
+		// CA is kept as ca-<serial>.pem so that both chains are trusted while leafs are reissued
+		caSecret, err := c.secrets.Get(c.namespace, common.CASecretName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		if _, ok := caSecret.GetAnnotations()[common.CARotateAnnotation]; !ok {
+			caSecret = caSecret.DeepCopy()
+			if caSecret.Annotations == nil {
+				caSecret.Annotations = map[string]string{}
+			}
+			caSecret.Annotations[common.CARotateAnnotation] = "true"
+
+			if _, err := c.secrets.Update(caSecret); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := c.pruneCAs(); err != nil {
+		return err
+	}
+
+	if err := c.setupPKI(); err != nil {
+		return err
+	}
+
+	if err := c.updateCRL(); err != nil {
+		return err
+	}
+
+	clusters, err := c.atlasClustersCache.List(c.namespace, labels.Everything())
+	if err != nil {
+		return err
+	}
+
+	for _, cluster := range clusters {
+		c.atlasClusters.Enqueue(cluster.Namespace, cluster.Name)
+	}
+
+	return nil
+}
+
+// crlRotationDue returns true when certificates have been revoked but the active certificate authority
+// cannot sign a CRL for them, certificate authorities generated before revocation support lack the key usage
+func (a *authority) crlRotationDue() bool {
+	return len(a.revoked) > 0 && a.crt.KeyUsage&x509.KeyUsageCRLSign == 0
+}
+
+// pruneCAs drops certificate authorities that have been expired or retired for longer than the
+// grace period from the CA secret, so that they are no longer part of the trust bundle, along with
+// the revocations of certificates that have expired or were issued by a pruned CA
+func (c *Controller) pruneCAs() error {
+	caSecret, err := c.secrets.Get(c.namespace, common.CASecretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	retired := caSecret.GetAnnotations()[common.CARetiredAnnotation]
+	revoked := caSecret.GetAnnotations()[common.CARevokedAnnotation]
+
+	caSecret = caSecret.DeepCopy()
+	now := time.Now()
+
+	retiredUncovered, err := retireUncoveredCAs(caSecret, now)
+	if err != nil {
+		return err
+	}
+	if len(retiredUncovered) > 0 {
+		c.log.WithField("serials", retiredUncovered).Warn("retired certificate authorities that cannot sign a CRL, revoked certificates are enforced once they are pruned")
+	}
+
+	pruned, err := pki.Prune(caSecret, c.config.CARetireGrace, now)
+	if err != nil {
+		return err
+	}
+
+	// Revocations of expired certificates and of certificates issued by a pruned CA no longer need a CRL entry
+	prunedRevocations, err := pki.PruneRevoked(caSecret, now)
+	if err != nil {
+		return err
+	}
+
+	annotations := caSecret.GetAnnotations()
+	if len(pruned) == 0 && retired == annotations[common.CARetiredAnnotation] && revoked == annotations[common.CARevokedAnnotation] {
+		return nil
+	}
+
+	if _, err := c.secrets.Update(caSecret); err != nil {
+		return err
+	}
+
+	if len(pruned) > 0 {
+		c.log.WithField("serials", pruned).Info("pruned certificate authorities from trust bundle")
+	}
+	if len(prunedRevocations) > 0 {
+		c.log.WithField("serials", prunedRevocations).Info("pruned revocations of expired certificates")
+	}
+
+	return nil
+}
+
+// retireUncoveredCAs retires the previous certificate authorities a CRL cannot be signed for once a certificate
+// has been revoked, no CRL is published while they are trusted. They are pruned after the grace period.
+func retireUncoveredCAs(caSecret *corev1.Secret, now time.Time) ([]string, error) {
+	revoked, err := pki.Revoked(caSecret)
+	if err != nil || len(revoked) == 0 {
+		return nil, err
+	}
+
+	serials, err := pki.CRLUncovered(caSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	uncovered := map[string]bool{}
+	for _, serial := range serials {
+		uncovered[serial] = true
+	}
+
+	cas, err := pki.List(caSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	retired := []string{}
+	for _, ca := range cas {
+		if ca.Active || ca.RetiredAt != nil || !uncovered[ca.Serial] {
+			continue
+		}
+
+		if err := pki.Retire(caSecret, ca.Serial, now); err != nil {
+			return nil, err
+		}
+		retired = append(retired, ca.Serial)
+	}
+
+	return retired, nil
+}
+
+// certRotationDue returns true if the certificate stored in the secret has used up the
+// configured fraction of its lifetime, secrets that cannot be parsed are always due
+func (c *Controller) certRotationDue(secret *corev1.Secret) bool {
+	cert, err := decodePEM(secret.Data["tls.crt"])
+	if err != nil {
+		return true
+	}
+
+	return rotationDue(cert, c.config.CertRotateFraction)
+}
+
+// rotationDue returns true once the certificate has used up the given fraction of its lifetime
+func rotationDue(cert *x509.Certificate, fraction float64) bool {
+	if fraction <= 0 {
+		return false
+	}
+
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	threshold := cert.NotBefore.Add(time.Duration(float64(lifetime) * fraction))
+
+	return time.Now().After(threshold)
+}
+
+// updateCertificateMetrics reports the expiry of the active certificate authority and of every leaf
+// certificate in the namespace, including the ones issued through cert-manager
+func (c *Controller) updateCertificateMetrics() {
+	c.metricsLock.Lock()
+	defer c.metricsLock.Unlock()
+
+	secrets, err := c.secretsCache.List(c.namespace, labels.SelectorFromSet(labels.Set{common.IsCertLabel: "true"}))
+	if err != nil {
+		c.log.WithError(err).Error("unable to list certificates for metrics")
+		return
+	}
+
+	certificateNotAfter.Reset()
+
+	if ca := c.authority(); ca != nil && ca.crt != nil {
+		certificateNotAfter.WithLabelValues(certKindCA, common.CASecretName, "").Set(float64(ca.crt.NotAfter.Unix()))
+	}
+
+	for _, secret := range secrets {
+		kind := certificateKind(secret)
+		if kind == "" {
+			continue
+		}
+
+		cert, err := decodePEM(secret.Data["tls.crt"])
+		if err != nil {
+			continue
+		}
+
+		certificateNotAfter.WithLabelValues(kind, secret.Name, secret.GetLabels()[common.CAClusterLabel]).Set(float64(cert.NotAfter.Unix()))
+	}
+}
+
+// certificateKind returns the kind of certificate stored in the secret, or an empty string if it is not one Atlas manages
+func certificateKind(secret *corev1.Secret) string {
+	if _, ok := secret.GetLabels()[common.CAClusterLabel]; ok {
+		return certKindCluster
+	}
+
+	switch secret.Name {
+	case common.IngressTLSSecretName:
+		return certKindIngress
+	case common.ClientSecretName:
+		return certKindClient
+	case common.ServerSecretName:
+		return certKindServer
+	}
+
+	return ""
+}
@@ -0,0 +1,35 @@
+package crds
+
+import (
+	"context"
+
+	"github.com/rancher/wrangler/pkg/crd"
+	"k8s.io/client-go/rest"
+
+	"github.com/goatlas-io/atlas/pkg/apis/atlas.goatlas.io/v1alpha1"
+)
+
+// List returns the CustomResourceDefinitions owned by Atlas
+func List() []crd.CRD {
+	return []crd.CRD{
+		crd.FromGV(v1alpha1.SchemeGroupVersion, "AtlasCluster").
+			WithSchemaFromStruct(v1alpha1.AtlasCluster{}).
+			WithStatus().
+			WithShortNames("ac").
+			WithColumn("Ready", `.status.conditions[?(@.type=="Ready")].status`).
+			WithColumn("Reason", `.status.conditions[?(@.type=="Ready")].message`).
+			WithColumn("Addresses", ".spec.externalAddresses").
+			WithColumn("Replicas", ".spec.replicas").
+			WithColumn("Snapshot", ".status.lastSnapshotVersion"),
+	}
+}
+
+// Create creates (or updates) all CustomResourceDefinitions and waits for them to become established
+func Create(ctx context.Context, cfg *rest.Config) error {
+	factory, err := crd.NewFactoryFromClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	return factory.BatchCreateCRDs(ctx, List()...).BatchWait()
+}
@@ -2,10 +2,15 @@ package envoy
 
 import (
 	"context"
+	"fmt"
 	"sync"
+	"time"
 
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	"github.com/sirupsen/logrus"
+
+	"github.com/goatlas-io/atlas/pkg/common"
 )
 
 type Callbacks struct {
@@ -16,6 +21,21 @@ type Callbacks struct {
 	DeltaResponses int
 	mu             sync.Mutex
 	log            *logrus.Entry
+
+	// streams maps stream ids to the node id that opened them
+	streams map[int64]string
+	// nodeStreams counts the open streams per node id
+	nodeStreams map[string]int
+	// onNodeChanged is called when a node opens its first or closes its last stream
+	onNodeChanged func(nodeID string)
+
+	// verifyNodes rejects streams whose node id does not match the identity of the peer
+	verifyNodes bool
+	// identities maps stream ids to the identity of the peer that opened them
+	identities map[int64]streamIdentity
+
+	// configStatus maps node ids and type urls to the config last sent to and acknowledged by the node
+	configStatus map[string]map[string]*ConfigStatus
 }
 
 func (cb *Callbacks) Report() {
@@ -23,43 +43,79 @@ func (cb *Callbacks) Report() {
 	defer cb.mu.Unlock()
 	cb.log.WithField("fetches", cb.Fetches).WithField("requests", cb.Requests).Info("server callbacks")
 }
-func (cb *Callbacks) OnStreamOpen(_ context.Context, id int64, typ string) error {
+func (cb *Callbacks) OnStreamOpen(ctx context.Context, id int64, typ string) error {
 	cb.log.WithField("id", id).WithField("type", typ).Debug("stream open")
 	connectedClients.Inc()
+	cb.openIdentity(ctx, id)
 	return nil
 }
 func (cb *Callbacks) OnStreamClosed(id int64) {
 	cb.log.WithField("id", id).Debug("stream closed")
 	connectedClients.Dec()
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.closeStream(id)
 }
-func (cb *Callbacks) OnDeltaStreamOpen(_ context.Context, id int64, typ string) error {
+func (cb *Callbacks) OnDeltaStreamOpen(ctx context.Context, id int64, typ string) error {
 	cb.log.WithField("id", id).WithField("type", typ).Debug("delta stream open")
+	connectedClients.Inc()
+	cb.openIdentity(ctx, id)
 	return nil
 }
 func (cb *Callbacks) OnDeltaStreamClosed(id int64) {
 	cb.log.WithField("id", id).Debug("delta stream closed")
+	connectedClients.Dec()
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.closeStream(id)
 }
-func (cb *Callbacks) OnStreamRequest(int64, *discovery.DiscoveryRequest) error {
+func (cb *Callbacks) OnStreamRequest(id int64, req *discovery.DiscoveryRequest) error {
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 	cb.Requests++
+
+	if err := cb.verifyNode(id, req.GetNode().GetId()); err != nil {
+		return err
+	}
+
+	cb.trackNode(id, req.GetNode())
+	cb.recordRequest(id, req.GetTypeUrl(), req.GetResponseNonce(), req.GetErrorDetail() != nil, req.GetErrorDetail().GetMessage())
+
 	if cb.Signal != nil {
 		close(cb.Signal)
 		cb.Signal = nil
 	}
 	return nil
 }
-func (cb *Callbacks) OnStreamResponse(int64, *discovery.DiscoveryRequest, *discovery.DiscoveryResponse) {
+func (cb *Callbacks) OnStreamResponse(id int64, req *discovery.DiscoveryRequest, res *discovery.DiscoveryResponse) {
+	resourcesSent.WithLabelValues("sotw", res.GetTypeUrl()).Add(float64(len(res.GetResources())))
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.recordResponse(id, res.GetTypeUrl(), res.GetVersionInfo(), res.GetNonce())
 }
 func (cb *Callbacks) OnStreamDeltaResponse(id int64, req *discovery.DeltaDiscoveryRequest, res *discovery.DeltaDiscoveryResponse) {
+	resourcesSent.WithLabelValues("delta", res.GetTypeUrl()).Add(float64(len(res.GetResources())))
+
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 	cb.DeltaResponses++
+	cb.recordResponse(id, res.GetTypeUrl(), res.GetSystemVersionInfo(), res.GetNonce())
 }
 func (cb *Callbacks) OnStreamDeltaRequest(id int64, req *discovery.DeltaDiscoveryRequest) error {
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 	cb.DeltaRequests++
+
+	if err := cb.verifyNode(id, req.GetNode().GetId()); err != nil {
+		return err
+	}
+
+	cb.trackNode(id, req.GetNode())
+	cb.recordRequest(id, req.GetTypeUrl(), req.GetResponseNonce(), req.GetErrorDetail() != nil, req.GetErrorDetail().GetMessage())
+
 	if cb.Signal != nil {
 		close(cb.Signal)
 		cb.Signal = nil
@@ -67,10 +123,15 @@ func (cb *Callbacks) OnStreamDeltaRequest(id int64, req *discovery.DeltaDiscover
 
 	return nil
 }
-func (cb *Callbacks) OnFetchRequest(_ context.Context, req *discovery.DiscoveryRequest) error {
+func (cb *Callbacks) OnFetchRequest(ctx context.Context, req *discovery.DiscoveryRequest) error {
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 	cb.Fetches++
+
+	if err := cb.verifyFetch(ctx, req.GetNode().GetId()); err != nil {
+		return err
+	}
+
 	if cb.Signal != nil {
 		close(cb.Signal)
 		cb.Signal = nil
@@ -78,3 +139,161 @@ func (cb *Callbacks) OnFetchRequest(_ context.Context, req *discovery.DiscoveryR
 	return nil
 }
 func (cb *Callbacks) OnFetchResponse(*discovery.DiscoveryRequest, *discovery.DiscoveryResponse) {}
+
+// NodeConnected returns true when the node has at least one open stream
+func (cb *Callbacks) NodeConnected(nodeID string) bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.nodeStreams[nodeID] > 0
+}
+
+// NodeStreams returns the ids of the open streams of every connected node
+func (cb *Callbacks) NodeStreams() map[string][]int64 {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	nodes := map[string][]int64{}
+	for id, nodeID := range cb.streams {
+		nodes[nodeID] = append(nodes[nodeID], id)
+	}
+
+	return nodes
+}
+
+// trackNode records the node that opened the stream, must be called with mu held
+func (cb *Callbacks) trackNode(id int64, node *core.Node) {
+	// Note: envoy only sends the node on the first request of a stream
+	if _, ok := cb.streams[id]; ok || node == nil {
+		return
+	}
+
+	nodeID := node.GetId()
+	cb.streams[id] = nodeID
+	cb.nodeStreams[nodeID]++
+	cb.updateClientCertificate(nodeID)
+
+	if cb.nodeStreams[nodeID] == 1 {
+		cb.nodeChanged(nodeID)
+	}
+}
+
+// closeStream forgets the stream and notifies when it was the last stream of its node, must be called with mu held
+func (cb *Callbacks) closeStream(id int64) {
+	delete(cb.identities, id)
+
+	nodeID, ok := cb.streams[id]
+	if !ok {
+		return
+	}
+
+	delete(cb.streams, id)
+	cb.nodeStreams[nodeID]--
+	cb.updateClientCertificate(nodeID)
+
+	if cb.nodeStreams[nodeID] <= 0 {
+		delete(cb.nodeStreams, nodeID)
+		cb.forgetConfigStatus(nodeID)
+		cb.nodeChanged(nodeID)
+	}
+}
+
+// updateClientCertificate reports the earliest expiry of the client certificates the open streams of the node
+// were authenticated with, that is the certificate deployed with the node and not the one last issued for it.
+// Must be called with mu held.
+func (cb *Callbacks) updateClientCertificate(nodeID string) {
+	cluster, _ := common.ClusterFromNodeID(nodeID)
+
+	var notAfter time.Time
+	for id, streamNode := range cb.streams {
+		identity, ok := cb.identities[id]
+		if streamNode != nodeID || !ok || !identity.authenticated {
+			continue
+		}
+		if notAfter.IsZero() || identity.notAfter.Before(notAfter) {
+			notAfter = identity.notAfter
+		}
+	}
+
+	if notAfter.IsZero() {
+		clientCertificateNotAfter.DeleteLabelValues(nodeID, cluster)
+		return
+	}
+
+	clientCertificateNotAfter.WithLabelValues(nodeID, cluster).Set(float64(notAfter.Unix()))
+}
+
+// nodeChanged notifies about node connection changes without blocking the stream, must be called with mu held
+func (cb *Callbacks) nodeChanged(nodeID string) {
+	if cb.onNodeChanged == nil {
+		return
+	}
+	go cb.onNodeChanged(nodeID)
+}
+
+// openIdentity records the peer identity of a newly opened stream when node verification is enabled
+func (cb *Callbacks) openIdentity(ctx context.Context, id int64) {
+	if !cb.verifyNodes {
+		return
+	}
+
+	identity := identityFromContext(ctx)
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.identities[id] = identity
+}
+
+// verifyNode rejects the request when the node id does not match the identity of the stream,
+// must be called with mu held
+func (cb *Callbacks) verifyNode(id int64, nodeID string) error {
+	// Note: envoy only sends the node on the first request of a stream
+	if !cb.verifyNodes || nodeID == "" {
+		return nil
+	}
+
+	identity, ok := cb.identities[id]
+	if !ok {
+		rejectedStreams.WithLabelValues("unknown-stream").Inc()
+		return fmt.Errorf("stream %d has no identity", id)
+	}
+
+	if !identity.allowsNode(nodeID) {
+		reason := "node-mismatch"
+		if !identity.authenticated {
+			reason = "unauthenticated"
+		}
+		rejectedStreams.WithLabelValues(reason).Inc()
+
+		cb.log.WithField("id", id).WithField("node", nodeID).WithField("identity", identity.names).WithField("reason", reason).Warn("rejected stream")
+		return fmt.Errorf("node %q is not allowed for this client identity", nodeID)
+	}
+
+	return nil
+}
+
+// verifyFetch rejects a unary fetch when the node id does not match the identity of the peer. Unlike
+// streams every fetch must carry the node id, there is no earlier request to take it from.
+func (cb *Callbacks) verifyFetch(ctx context.Context, nodeID string) error {
+	if !cb.verifyNodes {
+		return nil
+	}
+
+	if nodeID == "" {
+		rejectedStreams.WithLabelValues("missing-node").Inc()
+		return fmt.Errorf("fetch request has no node id")
+	}
+
+	identity := identityFromContext(ctx)
+	if !identity.allowsNode(nodeID) {
+		reason := "node-mismatch"
+		if !identity.authenticated {
+			reason = "unauthenticated"
+		}
+		rejectedStreams.WithLabelValues(reason).Inc()
+
+		cb.log.WithField("node", nodeID).WithField("identity", identity.names).WithField("reason", reason).Warn("rejected fetch")
+		return fmt.Errorf("node %q is not allowed for this client identity", nodeID)
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package envoy
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"testing"
+	"time"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// peerContext returns a context of a grpc peer that presented a verified certificate with the given names
+func peerContext(names ...string) context.Context {
+	return certificateContext(&x509.Certificate{DNSNames: names})
+}
+
+// certificateContext returns a context of a grpc peer that presented the verified certificate
+func certificateContext(cert *x509.Certificate) context.Context {
+	info := credentials.TLSInfo{}
+	info.State.PeerCertificates = []*x509.Certificate{cert}
+	info.State.VerifiedChains = [][]*x509.Certificate{{cert}}
+
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr:     &net.TCPAddr{},
+		AuthInfo: info,
+	})
+}
+
+func TestOnFetchRequestVerifiesTheNode(t *testing.T) {
+	cb := &Callbacks{
+		log:         logrus.NewEntry(logrus.New()),
+		verifyNodes: true,
+	}
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		nodeID  string
+		allowed bool
+	}{
+		{"own cluster", peerContext("east.cluster.atlas"), "cluster.east", true},
+		{"other cluster", peerContext("east.cluster.atlas"), "cluster.west", false},
+		{"observability node", peerContext("east.cluster.atlas"), "atlas", false},
+		{"empty node", peerContext("east.cluster.atlas"), "", false},
+		{"observability client", peerContext("client.atlas"), "atlas", true},
+		{"observability client for a cluster", peerContext("client.atlas"), "cluster.east", false},
+		{"plaintext observability", context.Background(), "atlas", true},
+		{"plaintext cluster", context.Background(), "cluster.east", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := &discovery.DiscoveryRequest{Node: &core.Node{Id: test.nodeID}}
+
+			err := cb.OnFetchRequest(test.ctx, req)
+			if test.allowed && err != nil {
+				t.Fatalf("expected the fetch to be allowed, got %v", err)
+			}
+			if !test.allowed && err == nil {
+				t.Fatal("expected the fetch to be rejected")
+			}
+		})
+	}
+}
+
+func TestClientCertificateExpiryOfConnectedNodes(t *testing.T) {
+	cb := &Callbacks{
+		log:          logrus.NewEntry(logrus.New()),
+		verifyNodes:  true,
+		streams:      map[int64]string{},
+		nodeStreams:  map[string]int{},
+		identities:   map[int64]streamIdentity{},
+		configStatus: map[string]map[string]*ConfigStatus{},
+	}
+
+	deployed := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	reissued := deployed.Add(240 * time.Hour)
+
+	open := func(id int64, notAfter time.Time) {
+		t.Helper()
+
+		ctx := certificateContext(&x509.Certificate{DNSNames: []string{"east.cluster.atlas"}, NotAfter: notAfter})
+		if err := cb.OnStreamOpen(ctx, id, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := cb.OnStreamRequest(id, &discovery.DiscoveryRequest{Node: &core.Node{Id: "cluster.east"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	open(1, deployed)
+	open(2, reissued)
+
+	// Note: a replica still running the previous bootstrap keeps the earlier expiry
+	if got := testutil.ToFloat64(clientCertificateNotAfter.WithLabelValues("cluster.east", "east")); got != float64(deployed.Unix()) {
+		t.Errorf("expected the expiry of the deployed certificate %d, got %f", deployed.Unix(), got)
+	}
+
+	cb.OnStreamClosed(1)
+	if got := testutil.ToFloat64(clientCertificateNotAfter.WithLabelValues("cluster.east", "east")); got != float64(reissued.Unix()) {
+		t.Errorf("expected the expiry of the reissued certificate %d, got %f", reissued.Unix(), got)
+	}
+
+	cb.OnStreamClosed(2)
+	if got := testutil.CollectAndCount(clientCertificateNotAfter); got != 0 {
+		t.Errorf("expected no expiry once the node disconnected, got %d series", got)
+	}
+}
@@ -0,0 +1,99 @@
+package envoy
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/goatlas-io/atlas/pkg/metrics"
+)
+
+// snapshotNode describes a node that has a snapshot or is connected to the ADS server
+type snapshotNode struct {
+	Node      string  `json:"node"`
+	Snapshot  bool    `json:"snapshot"`
+	Connected bool    `json:"connected"`
+	Streams   []int64 `json:"streams"`
+}
+
+// DebugHandlers returns the debug endpoints of the ADS server that are served by the metrics server
+func (e *EnvoyADS) DebugHandlers() []metrics.Handler {
+	return []metrics.Handler{
+		{Path: "/debug/config-status", Handler: http.HandlerFunc(e.callbacks.configStatusHandler)},
+		{Path: "/debug/snapshots", Handler: http.HandlerFunc(e.snapshotsHandler)},
+		{Path: "/debug/snapshots/{node}", Handler: http.HandlerFunc(e.snapshotHandler)},
+	}
+}
+
+// snapshotsHandler lists the nodes that have a snapshot along with the streams of the connected nodes
+func (e *EnvoyADS) snapshotsHandler(w http.ResponseWriter, r *http.Request) {
+	nodes := map[string]*snapshotNode{}
+
+	e.lock.Lock()
+	for nodeID := range e.snapshotHashes {
+		nodes[nodeID] = &snapshotNode{Node: nodeID, Snapshot: true, Streams: []int64{}}
+	}
+	e.lock.Unlock()
+
+	for nodeID, streams := range e.callbacks.NodeStreams() {
+		node, ok := nodes[nodeID]
+		if !ok {
+			node = &snapshotNode{Node: nodeID}
+			nodes[nodeID] = node
+		}
+
+		sort.Slice(streams, func(i, j int) bool { return streams[i] < streams[j] })
+		node.Connected = true
+		node.Streams = streams
+	}
+
+	list := []*snapshotNode{}
+	for _, node := range nodes {
+		list = append(list, node)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Node < list[j].Node })
+
+	e.writeView(w, r, map[string]interface{}{"nodes": list})
+}
+
+// snapshotHandler serves the resources of the snapshot of a node, private keys are redacted
+func (e *EnvoyADS) snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	nodeID := mux.Vars(r)["node"]
+
+	snapshot, err := e.cache.GetSnapshot(nodeID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	view, err := RenderSnapshot(nodeID, snapshot, true)
+	if err != nil {
+		e.log.WithError(err).WithField("node", nodeID).Error("unable to render snapshot")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	e.writeView(w, r, view)
+}
+
+// writeView encodes the view as JSON, or as YAML when asked for by the format parameter or the accept header
+func (e *EnvoyADS) writeView(w http.ResponseWriter, r *http.Request, view interface{}) {
+	asYAML := r.URL.Query().Get("format") == "yaml" || strings.Contains(r.Header.Get("Accept"), "yaml")
+
+	data, err := MarshalView(view, asYAML)
+	if err != nil {
+		e.log.WithError(err).Error("unable to encode debug view")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if asYAML {
+		w.Header().Set("Content-Type", "application/yaml")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+
+	_, _ = w.Write(data)
+}
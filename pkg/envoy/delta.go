@@ -0,0 +1,252 @@
+package envoy
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/delta/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// deltaStreamIDOffset keeps the ids of delta streams apart from the ids of state of the world streams,
+// both are tracked by the same callbacks
+const deltaStreamIDOffset = 1 << 62
+
+// deltaServer serves the incremental xDS protocol from the delta watches of the snapshot cache, the delta
+// server of go-control-plane v0.9.9 does not handle streams yet
+type deltaServer struct {
+	ctx       context.Context
+	cache     cache.ConfigWatcher
+	callbacks delta.Callbacks
+
+	streamCount int64
+}
+
+func newDeltaServer(ctx context.Context, config cache.ConfigWatcher, callbacks delta.Callbacks) delta.Server {
+	return &deltaServer{
+		ctx:       ctx,
+		cache:     config,
+		callbacks: callbacks,
+	}
+}
+
+// deltaWatch is the state of one resource type on a delta stream
+type deltaWatch struct {
+	nonce string
+	state stream.StreamState
+	// req is the request the open watch was created for, responses of previous watches are dropped
+	req    *discovery.DeltaDiscoveryRequest
+	cancel func()
+	// done stops the goroutine forwarding the response of the watch
+	done chan struct{}
+}
+
+func (w *deltaWatch) stop() {
+	if w.cancel != nil {
+		w.cancel()
+	}
+	if w.done != nil {
+		close(w.done)
+		w.done = nil
+	}
+}
+
+// DeltaStreamHandler converts the blocking receive calls of the stream to a channel and processes the stream
+func (s *deltaServer) DeltaStreamHandler(str stream.DeltaStream, typeURL string) error {
+	reqCh := make(chan *discovery.DeltaDiscoveryRequest)
+	go func() {
+		defer close(reqCh)
+		for {
+			req, err := str.Recv()
+			if err != nil {
+				return
+			}
+			select {
+			case reqCh <- req:
+			case <-str.Context().Done():
+				return
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return s.process(str, reqCh, typeURL)
+}
+
+func (s *deltaServer) process(str stream.DeltaStream, reqCh <-chan *discovery.DeltaDiscoveryRequest, defaultTypeURL string) error {
+	streamID := deltaStreamIDOffset + atomic.AddInt64(&s.streamCount, 1)
+
+	// Note: nonces are unique per stream, stale acknowledgements are recognized by them
+	var streamNonce int64
+
+	watches := map[string]*deltaWatch{}
+	responses := make(chan cache.DeltaResponse, 5)
+
+	defer func() {
+		for _, watch := range watches {
+			watch.stop()
+		}
+		s.callbacks.OnDeltaStreamClosed(streamID)
+	}()
+
+	if err := s.callbacks.OnDeltaStreamOpen(str.Context(), streamID, defaultTypeURL); err != nil {
+		return err
+	}
+
+	send := func(resp cache.DeltaResponse) (string, error) {
+		out, err := resp.GetDeltaDiscoveryResponse()
+		if err != nil {
+			return "", err
+		}
+
+		streamNonce++
+		out.Nonce = strconv.FormatInt(streamNonce, 10)
+		s.callbacks.OnStreamDeltaResponse(streamID, resp.GetDeltaRequest(), out)
+
+		return out.Nonce, str.Send(out)
+	}
+
+	// node may only be set on the first discovery request
+	node := &core.Node{}
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return nil
+
+		case resp := <-responses:
+			watch, ok := watches[resp.GetDeltaRequest().GetTypeUrl()]
+			if !ok || watch.req != resp.GetDeltaRequest() {
+				continue
+			}
+
+			nonce, err := send(resp)
+			if err != nil {
+				return err
+			}
+
+			// Note: resources the cache did not have are dropped from the versions, envoy was told they are removed
+			watch.nonce = nonce
+			watch.state.ResourceVersions = resp.GetNextVersionMap()
+
+		case req, more := <-reqCh:
+			if !more {
+				return nil
+			}
+			if req == nil {
+				return status.Errorf(codes.Unavailable, "empty request")
+			}
+
+			if req.Node != nil {
+				node = req.Node
+			} else {
+				req.Node = node
+			}
+
+			if defaultTypeURL == resource.AnyType {
+				if req.TypeUrl == "" {
+					return status.Errorf(codes.InvalidArgument, "type URL is required for ADS")
+				}
+			} else if req.TypeUrl == "" {
+				req.TypeUrl = defaultTypeURL
+			}
+
+			if err := s.callbacks.OnStreamDeltaRequest(streamID, req); err != nil {
+				return err
+			}
+
+			watch, ok := watches[req.TypeUrl]
+			if !ok {
+				watch = &deltaWatch{
+					state: stream.StreamState{
+						IsWildcard:       len(req.GetResourceNamesSubscribe()) == 0,
+						ResourceVersions: map[string]string{},
+					},
+				}
+				for name, version := range req.GetInitialResourceVersions() {
+					watch.state.ResourceVersions[name] = version
+				}
+				watches[req.TypeUrl] = watch
+			} else if nonce := req.GetResponseNonce(); nonce != "" && nonce != watch.nonce {
+				// Note: a response is in flight, envoy acknowledges it with another request
+				continue
+			}
+
+			updateSubscriptions(&watch.state, req)
+
+			watch.stop()
+			if err := s.watch(watch, req, responses); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// watch opens a new delta watch in the cache and forwards its response, the cache reads the stream
+// state of the watch while it is open so it is given its own copy
+func (s *deltaServer) watch(watch *deltaWatch, req *discovery.DeltaDiscoveryRequest, responses chan<- cache.DeltaResponse) error {
+	state := stream.StreamState{
+		IsWildcard:       watch.state.IsWildcard,
+		ResourceVersions: map[string]string{},
+	}
+	for name, version := range watch.state.ResourceVersions {
+		state.ResourceVersions[name] = version
+	}
+
+	value, cancel := s.cache.CreateDeltaWatch(req, &state)
+	if value == nil {
+		return errors.New("delta xds is not supported by the cache")
+	}
+
+	done := make(chan struct{})
+	watch.req = req
+	watch.cancel = cancel
+	watch.done = done
+
+	go func() {
+		select {
+		case resp, more := <-value:
+			if !more {
+				return
+			}
+			select {
+			case responses <- resp:
+			case <-done:
+			}
+		case <-done:
+		}
+	}()
+
+	return nil
+}
+
+// updateSubscriptions applies the subscribed and unsubscribed resource names of the request, newly
+// subscribed resources have no version so they are sent in the next response
+func updateSubscriptions(state *stream.StreamState, req *discovery.DeltaDiscoveryRequest) {
+	for _, name := range req.GetResourceNamesSubscribe() {
+		if name == "*" {
+			state.IsWildcard = true
+			continue
+		}
+		if _, ok := state.ResourceVersions[name]; !ok {
+			state.ResourceVersions[name] = ""
+		}
+	}
+
+	for _, name := range req.GetResourceNamesUnsubscribe() {
+		if name == "*" {
+			state.IsWildcard = false
+			continue
+		}
+		delete(state.ResourceVersions, name)
+	}
+}
@@ -0,0 +1,191 @@
+package envoy
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"google.golang.org/grpc"
+)
+
+const deltaTestNode = "cluster.test"
+
+// fakeDeltaStream hands the responses of the server to the test
+type fakeDeltaStream struct {
+	grpc.ServerStream
+	ctx       context.Context
+	responses chan *discovery.DeltaDiscoveryResponse
+}
+
+func (f *fakeDeltaStream) Context() context.Context { return f.ctx }
+
+func (f *fakeDeltaStream) Send(resp *discovery.DeltaDiscoveryResponse) error {
+	f.responses <- resp
+	return nil
+}
+
+func (f *fakeDeltaStream) Recv() (*discovery.DeltaDiscoveryRequest, error) {
+	panic("requests are passed to process directly")
+}
+
+// fakeDeltaCallbacks accepts every stream and request
+type fakeDeltaCallbacks struct{}
+
+func (fakeDeltaCallbacks) OnDeltaStreamOpen(context.Context, int64, string) error { return nil }
+func (fakeDeltaCallbacks) OnDeltaStreamClosed(int64)                              {}
+func (fakeDeltaCallbacks) OnStreamDeltaRequest(int64, *discovery.DeltaDiscoveryRequest) error {
+	return nil
+}
+func (fakeDeltaCallbacks) OnStreamDeltaResponse(int64, *discovery.DeltaDiscoveryRequest, *discovery.DeltaDiscoveryResponse) {
+}
+
+type deltaTest struct {
+	t        *testing.T
+	cache    cache.SnapshotCache
+	stream   *fakeDeltaStream
+	requests chan *discovery.DeltaDiscoveryRequest
+	done     chan error
+}
+
+// newDeltaTest runs process on a fake stream against a snapshot cache holding the given clusters
+func newDeltaTest(t *testing.T, clusters map[string]string) *deltaTest {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	test := &deltaTest{
+		t:        t,
+		cache:    cache.NewSnapshotCache(false, cache.IDHash{}, nil),
+		stream:   &fakeDeltaStream{ctx: ctx, responses: make(chan *discovery.DeltaDiscoveryResponse, 10)},
+		requests: make(chan *discovery.DeltaDiscoveryRequest),
+		done:     make(chan error, 1),
+	}
+	test.setClusters("v1", clusters)
+
+	server := newDeltaServer(ctx, test.cache, fakeDeltaCallbacks{}).(*deltaServer)
+	go func() {
+		test.done <- server.process(test.stream, test.requests, resource.AnyType)
+	}()
+
+	t.Cleanup(func() {
+		close(test.requests)
+		if err := <-test.done; err != nil {
+			t.Errorf("unexpected error from process: %s", err)
+		}
+	})
+
+	return test
+}
+
+// setClusters publishes clusters by name, the upstream host sets them apart between snapshots
+func (d *deltaTest) setClusters(version string, clusters map[string]string) {
+	resources := []types.Resource{}
+	for name, host := range clusters {
+		resources = append(resources, buildCluster(name, host, 80, false, false))
+	}
+
+	if err := d.cache.SetSnapshot(deltaTestNode, cache.NewSnapshotWithResources(version, cache.SnapshotResources{
+		Clusters: resources,
+	})); err != nil {
+		d.t.Fatal(err)
+	}
+}
+
+func (d *deltaTest) send(nonce string, subscribe, unsubscribe []string) {
+	d.requests <- &discovery.DeltaDiscoveryRequest{
+		Node:                     &core.Node{Id: deltaTestNode},
+		TypeUrl:                  resource.ClusterType,
+		ResponseNonce:            nonce,
+		ResourceNamesSubscribe:   subscribe,
+		ResourceNamesUnsubscribe: unsubscribe,
+	}
+}
+
+// expect waits for the next response and checks the names of the resources it carries
+func (d *deltaTest) expect(names ...string) *discovery.DeltaDiscoveryResponse {
+	d.t.Helper()
+
+	select {
+	case resp := <-d.stream.responses:
+		got := []string{}
+		for _, r := range resp.GetResources() {
+			got = append(got, r.GetName())
+		}
+		sort.Strings(got)
+		sort.Strings(names)
+
+		if len(got) != len(names) {
+			d.t.Fatalf("expected resources %v, got %v", names, got)
+		}
+		for i := range got {
+			if got[i] != names[i] {
+				d.t.Fatalf("expected resources %v, got %v", names, got)
+			}
+		}
+		return resp
+	case <-time.After(2 * time.Second):
+		d.t.Fatalf("expected a response with resources %v", names)
+	}
+	return nil
+}
+
+// expectNothing checks that no response is sent
+func (d *deltaTest) expectNothing() {
+	d.t.Helper()
+
+	select {
+	case resp := <-d.stream.responses:
+		d.t.Fatalf("expected no response, got %v", resp)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestDeltaWildcardSubscribeAndAck(t *testing.T) {
+	d := newDeltaTest(t, map[string]string{"a": "a.example.com", "b": "b.example.com"})
+
+	d.send("", nil, nil)
+	resp := d.expect("a", "b")
+
+	// The acknowledgement opens a new watch that only fires once something changes
+	d.send(resp.Nonce, nil, nil)
+	d.expectNothing()
+
+	d.setClusters("v2", map[string]string{"a": "a.example.com", "b": "changed.example.com"})
+	d.expect("b")
+}
+
+func TestDeltaStaleNonceIsIgnored(t *testing.T) {
+	d := newDeltaTest(t, map[string]string{"a": "a.example.com", "b": "b.example.com"})
+
+	d.send("", []string{"a"}, nil)
+	resp := d.expect("a")
+
+	// A request for an earlier response must not change the subscriptions
+	d.send("stale", []string{"b"}, nil)
+	d.expectNothing()
+
+	d.send(resp.Nonce, []string{"b"}, nil)
+	d.expect("b")
+}
+
+func TestDeltaUnsubscribe(t *testing.T) {
+	d := newDeltaTest(t, map[string]string{"a": "a.example.com", "b": "b.example.com"})
+
+	d.send("", []string{"a", "b"}, nil)
+	resp := d.expect("a", "b")
+
+	d.send(resp.Nonce, nil, []string{"a"})
+	d.expectNothing()
+
+	// Changes to the unsubscribed resource are not sent anymore
+	d.setClusters("v2", map[string]string{"a": "changed.example.com", "b": "b.example.com"})
+	d.expectNothing()
+
+	d.setClusters("v3", map[string]string{"a": "changed.example.com", "b": "changed.example.com"})
+	d.expect("b")
+}
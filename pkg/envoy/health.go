@@ -0,0 +1,489 @@
+package envoy
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	healthservice "github.com/envoyproxy/go-control-plane/envoy/service/health/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/goatlas-io/atlas/pkg/apis/atlas.goatlas.io/v1alpha1"
+	"github.com/goatlas-io/atlas/pkg/common"
+)
+
+// healthReportInterval is how often the observability envoy reports the health of the endpoints
+const healthReportInterval = 10 * time.Second
+
+// Upstreams of a downstream cluster that are health checked, used as the upstream label of the health metrics
+const (
+	upstreamThanos     = "thanos"
+	upstreamPrometheus = "prometheus"
+)
+
+// healthCheckConfig holds the active health check settings of a downstream cluster
+type healthCheckConfig struct {
+	disabled           bool
+	interval           time.Duration
+	timeout            time.Duration
+	unhealthyThreshold uint32
+	healthyThreshold   uint32
+	thanosService      string
+	prometheusPath     string
+}
+
+// newHealthCheckConfig applies the health check settings of a cluster onto the defaults
+func newHealthCheckConfig(spec *v1alpha1.HealthCheck) (healthCheckConfig, error) {
+	config := healthCheckConfig{
+		interval:           healthCheckInterval,
+		timeout:            healthCheckTimeout,
+		unhealthyThreshold: healthCheckUnhealthyThreshold,
+		healthyThreshold:   healthCheckHealthyThreshold,
+		prometheusPath:     healthCheckPrometheusPath,
+	}
+
+	if spec == nil {
+		return config, nil
+	}
+
+	config.disabled = spec.Disabled
+	config.thanosService = spec.ThanosService
+
+	if spec.Interval != "" {
+		d, err := time.ParseDuration(spec.Interval)
+		if err != nil || d <= 0 {
+			return config, fmt.Errorf("invalid health check interval %q", spec.Interval)
+		}
+		config.interval = d
+	}
+
+	if spec.Timeout != "" {
+		d, err := time.ParseDuration(spec.Timeout)
+		if err != nil || d <= 0 {
+			return config, fmt.Errorf("invalid health check timeout %q", spec.Timeout)
+		}
+		config.timeout = d
+	}
+
+	if spec.UnhealthyThreshold > 0 {
+		config.unhealthyThreshold = spec.UnhealthyThreshold
+	}
+	if spec.HealthyThreshold > 0 {
+		config.healthyThreshold = spec.HealthyThreshold
+	}
+	if spec.PrometheusPath != "" {
+		config.prometheusPath = spec.PrometheusPath
+	}
+
+	return config, nil
+}
+
+// healthCheckTarget is a cluster of the observability envoy whose endpoints are health checked
+type healthCheckTarget struct {
+	// envoyCluster is the name of the cluster in the snapshot of the observability envoy
+	envoyCluster string
+	cluster      string
+	upstream     string
+	hosts        []string
+	port         uint32
+	healthChecks []*core.HealthCheck
+	// transportSocket is the transport socket of the cluster, health checks connect the same way
+	transportSocket *core.TransportSocket
+}
+
+// newHealthCheckTarget builds the target of an envoy cluster of a downstream cluster
+func newHealthCheckTarget(c *cluster.Cluster, downstream *atlasCluster, upstream string, port uint32) healthCheckTarget {
+	return healthCheckTarget{
+		envoyCluster:    c.Name,
+		cluster:         downstream.Name,
+		upstream:        upstream,
+		hosts:           downstream.Addresses,
+		port:            port,
+		healthChecks:    c.HealthChecks,
+		transportSocket: c.TransportSocket,
+	}
+}
+
+// healthDiscoveryServer assigns the health checks of the downstream clusters to the observability envoy through
+// the health discovery service (HDS) and exports the health it reports back as metrics. The clusters of the
+// envoy do not check these endpoints themselves, the reported health is published to them through EDS.
+type healthDiscoveryServer struct {
+	log *logrus.Entry
+
+	// verifyNodes rejects streams whose node id does not match the identity of the peer
+	verifyNodes bool
+
+	mu      sync.Mutex
+	targets map[string]healthCheckTarget
+	// changed is closed and replaced whenever the targets are updated
+	changed chan struct{}
+	// streams is the number of open streams, the health metrics are removed once the last one closes
+	streams int
+	// series are the labels of the exported health metrics by envoy cluster
+	series map[string]*healthSeries
+	// health is the last reported health of the endpoints by envoy cluster and address
+	health map[string]map[string]core.HealthStatus
+	// onHealthChanged is called when the reported health of an endpoint changed
+	onHealthChanged func()
+}
+
+// healthSeries are the labels of the health metrics of an envoy cluster
+type healthSeries struct {
+	cluster   string
+	upstream  string
+	addresses map[string]bool
+}
+
+func newHealthDiscoveryServer(log *logrus.Entry, verifyNodes bool) *healthDiscoveryServer {
+	return &healthDiscoveryServer{
+		log:         log,
+		verifyNodes: verifyNodes,
+		targets:     map[string]healthCheckTarget{},
+		changed:     make(chan struct{}),
+		series:      map[string]*healthSeries{},
+		health:      map[string]map[string]core.HealthStatus{},
+	}
+}
+
+// update replaces the health check targets, open streams are sent the new health checks. Nothing is done on
+// a nil server, snapshots rendered offline are not health checked.
+func (s *healthDiscoveryServer) update(targets []healthCheckTarget) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.targets = map[string]healthCheckTarget{}
+	for _, target := range targets {
+		s.targets[target.envoyCluster] = target
+	}
+
+	// Note: health of clusters that are no longer checked is not reported anymore
+	for envoyCluster := range s.series {
+		if _, ok := s.targets[envoyCluster]; !ok {
+			s.forgetSeries(envoyCluster)
+		}
+	}
+
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// StreamHealthCheck assigns the health checks to the observability envoy and records the health it reports
+func (s *healthDiscoveryServer) StreamHealthCheck(stream healthservice.HealthDiscoveryService_StreamHealthCheckServer) error {
+	ctx := stream.Context()
+
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+
+	req := first.GetHealthCheckRequest()
+	if req == nil {
+		return status.Errorf(codes.InvalidArgument, "the first message must be a health check request")
+	}
+
+	nodeID := req.GetNode().GetId()
+	if nodeID != common.EnvoyADSObservabilityID {
+		return status.Errorf(codes.PermissionDenied, "health checks are only assigned to the observability envoy")
+	}
+	if s.verifyNodes && !identityFromContext(ctx).allowsNode(nodeID) {
+		rejectedStreams.WithLabelValues("node-mismatch").Inc()
+		return status.Errorf(codes.PermissionDenied, "node %q is not allowed for this client identity", nodeID)
+	}
+
+	s.openStream()
+	defer s.closeStream()
+
+	log := s.log.WithField("node", nodeID)
+	log.Debug("health check stream open")
+
+	responses := make(chan *healthservice.EndpointHealthResponse)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := stream.Recv()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if resp := msg.GetEndpointHealthResponse(); resp != nil {
+				select {
+				case responses <- resp:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	var sent *healthservice.HealthCheckSpecifier
+	for {
+		s.mu.Lock()
+		changed := s.changed
+		targets := make([]healthCheckTarget, 0, len(s.targets))
+		for _, target := range s.targets {
+			targets = append(targets, target)
+		}
+		s.mu.Unlock()
+
+		specifier := buildHealthCheckSpecifier(targets)
+		if sent == nil || !proto.Equal(sent, specifier) {
+			if err := stream.Send(specifier); err != nil {
+				return err
+			}
+			sent = specifier
+		}
+
+		// Note: the health checks are only rebuilt once the targets changed
+		waiting := true
+		for waiting {
+			select {
+			case <-ctx.Done():
+				return nil
+			case err := <-errs:
+				log.WithError(err).Debug("health check stream closed")
+				return nil
+			case resp := <-responses:
+				s.record(resp)
+			case <-changed:
+				waiting = false
+			}
+		}
+	}
+}
+
+// FetchHealthCheck is not supported, envoy only uses the streaming health discovery service
+func (s *healthDiscoveryServer) FetchHealthCheck(context.Context, *healthservice.HealthCheckRequestOrEndpointHealthResponse) (*healthservice.HealthCheckSpecifier, error) {
+	return nil, status.Errorf(codes.Unimplemented, "only the streaming health discovery service is supported")
+}
+
+func (s *healthDiscoveryServer) openStream() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.streams++
+}
+
+func (s *healthDiscoveryServer) closeStream() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.streams--
+	if s.streams > 0 {
+		return
+	}
+
+	// Note: the endpoints are published without health once nothing checks them anymore
+	changed := len(s.health) > 0
+	for envoyCluster := range s.series {
+		s.forgetSeries(envoyCluster)
+	}
+
+	if changed {
+		s.healthChanged()
+	}
+}
+
+// healthChanged notifies about changed endpoint health without blocking the stream, must be called with mu held
+func (s *healthDiscoveryServer) healthChanged() {
+	if s.onHealthChanged == nil {
+		return
+	}
+	go s.onHealthChanged()
+}
+
+// endpointHealth returns the last reported health of the endpoints of the envoy cluster by address, it is empty
+// on a nil server
+func (s *healthDiscoveryServer) endpointHealth(envoyCluster string) map[string]core.HealthStatus {
+	health := map[string]core.HealthStatus{}
+	if s == nil {
+		return health
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for address, status := range s.health[envoyCluster] {
+		health[address] = status
+	}
+
+	return health
+}
+
+// record exports the reported health of the endpoints, the latest report wins when several envoys report
+func (s *healthDiscoveryServer) record(resp *healthservice.EndpointHealthResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changed := false
+	for _, clusterHealth := range resp.GetClusterEndpointsHealth() {
+		target, ok := s.targets[clusterHealth.GetClusterName()]
+		if !ok {
+			continue
+		}
+
+		previous := s.series[target.envoyCluster]
+		series := &healthSeries{cluster: target.cluster, upstream: target.upstream, addresses: map[string]bool{}}
+		health := map[string]core.HealthStatus{}
+
+		healthy := 0
+		for _, locality := range clusterHealth.GetLocalityEndpointsHealth() {
+			for _, endpointHealth := range locality.GetEndpointsHealth() {
+				address := endpointHealth.GetEndpoint().GetAddress().GetSocketAddress().GetAddress()
+
+				value := 0.0
+				if endpointHealth.GetHealthStatus() == core.HealthStatus_HEALTHY {
+					value = 1
+					healthy++
+				}
+
+				upstreamEndpointHealthy.WithLabelValues(series.cluster, series.upstream, address).Set(value)
+				series.addresses[address] = true
+				health[address] = endpointHealth.GetHealthStatus()
+			}
+		}
+
+		if !equalHealth(s.health[target.envoyCluster], health) {
+			changed = true
+		}
+
+		if previous != nil {
+			for address := range previous.addresses {
+				if !series.addresses[address] {
+					upstreamEndpointHealthy.DeleteLabelValues(previous.cluster, previous.upstream, address)
+				}
+			}
+		}
+
+		upstreamHealthyEndpoints.WithLabelValues(series.cluster, series.upstream).Set(float64(healthy))
+		s.series[target.envoyCluster] = series
+		s.health[target.envoyCluster] = health
+	}
+
+	if changed {
+		s.healthChanged()
+	}
+}
+
+// equalHealth returns true when both reports contain the same endpoints with the same health
+func equalHealth(a, b map[string]core.HealthStatus) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for address, status := range a {
+		if other, ok := b[address]; !ok || other != status {
+			return false
+		}
+	}
+	return true
+}
+
+// forgetSeries removes the health metrics of an envoy cluster, must be called with mu held
+func (s *healthDiscoveryServer) forgetSeries(envoyCluster string) {
+	series, ok := s.series[envoyCluster]
+	if !ok {
+		return
+	}
+
+	for address := range series.addresses {
+		upstreamEndpointHealthy.DeleteLabelValues(series.cluster, series.upstream, address)
+	}
+	upstreamHealthyEndpoints.DeleteLabelValues(series.cluster, series.upstream)
+
+	delete(s.series, envoyCluster)
+	delete(s.health, envoyCluster)
+}
+
+// buildHealthCheckSpecifier builds the health checks of the targets, the health discovery service only accepts
+// IPs so only clusters whose hosts are all IPs are delegated to it
+func buildHealthCheckSpecifier(targets []healthCheckTarget) *healthservice.HealthCheckSpecifier {
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].envoyCluster < targets[j].envoyCluster
+	})
+
+	specifier := &healthservice.HealthCheckSpecifier{
+		ClusterHealthChecks: []*healthservice.ClusterHealthCheck{},
+		Interval:            ptypes.DurationProto(healthReportInterval),
+	}
+
+	for _, target := range targets {
+		if len(target.healthChecks) == 0 {
+			continue
+		}
+
+		hosts := append([]string{}, target.hosts...)
+		sort.Strings(hosts)
+
+		endpoints := []*endpoint.Endpoint{}
+		for _, host := range hosts {
+			endpoints = append(endpoints, &endpoint.Endpoint{
+				Address: &core.Address{
+					Address: &core.Address_SocketAddress{
+						SocketAddress: &core.SocketAddress{
+							Protocol: core.SocketAddress_TCP,
+							Address:  host,
+							PortSpecifier: &core.SocketAddress_PortValue{
+								PortValue: target.port,
+							},
+						},
+					},
+				},
+			})
+		}
+
+		check := &healthservice.ClusterHealthCheck{
+			ClusterName:       target.envoyCluster,
+			HealthChecks:      target.healthChecks,
+			LocalityEndpoints: []*healthservice.LocalityEndpoints{{Endpoints: endpoints}},
+		}
+
+		if target.transportSocket != nil {
+			// Note: a match without criteria applies to every endpoint
+			check.TransportSocketMatches = []*cluster.Cluster_TransportSocketMatch{{
+				Name:            "default",
+				Match:           &structpb.Struct{},
+				TransportSocket: target.transportSocket,
+			}}
+		}
+
+		specifier.ClusterHealthChecks = append(specifier.ClusterHealthChecks, check)
+	}
+
+	return specifier
+}
+
+// delegateHealthChecks moves the active health checks of a cluster discovered through EDS to the health
+// discovery service, so the observability envoy does not run every check twice. The health it reports is set
+// on the endpoints of the load assignment, unhealthy endpoints are therefore still avoided. Clusters of DNS
+// names keep their health checks, the ADS server does not resolve them. Returns false when the cluster keeps them.
+func (e *EnvoyADS) delegateHealthChecks(c *cluster.Cluster, assignment *endpoint.ClusterLoadAssignment, downstream *atlasCluster, upstream string, port uint32) (healthCheckTarget, bool) {
+	if !e.config.HealthDiscovery || assignment == nil || len(c.HealthChecks) == 0 {
+		return healthCheckTarget{}, false
+	}
+
+	target := newHealthCheckTarget(c, downstream, upstream, port)
+	c.HealthChecks = nil
+
+	health := e.hds.endpointHealth(c.Name)
+	for _, locality := range assignment.GetEndpoints() {
+		for _, lbEndpoint := range locality.GetLbEndpoints() {
+			if status, ok := health[lbEndpoint.GetEndpoint().GetAddress().GetSocketAddress().GetAddress()]; ok {
+				lbEndpoint.HealthStatus = status
+			}
+		}
+	}
+
+	return target, true
+}
@@ -2,6 +2,9 @@ package envoy
 
 import (
 	"bytes"
+	"net"
+	"sort"
+	"strings"
 	"time"
 
 	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
@@ -9,17 +12,39 @@ import (
 	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	tlsinspector "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/listener/tls_inspector/v3"
 	hcm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	tcp "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
 	tls "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
 	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	envoytype "github.com/envoyproxy/go-control-plane/envoy/type/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
 	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 	k8scorev1 "k8s.io/api/core/v1"
 )
 
-func buildCluster(clusterName, upstreamHost string, upstreamPort uint32, upstreamTLS bool, http2 bool) *cluster.Cluster {
+// Health checking and outlier detection of the clusters of downstream cluster envoys, the health check
+// settings can be overridden per cluster
+const (
+	healthCheckTimeout            = 5 * time.Second
+	healthCheckInterval           = 10 * time.Second
+	healthCheckUnhealthyThreshold = 3
+	healthCheckHealthyThreshold   = 1
+	healthCheckPrometheusPath     = "/-/ready"
+
+	outlierConsecutiveErrors  = 5
+	outlierInterval           = 10 * time.Second
+	outlierBaseEjectionTime   = 30 * time.Second
+	outlierMaxEjectionPercent = 50
+)
+
+// buildCluster builds a cluster for a single upstream host, when upstreamSANs are given the upstream
+// server certificate must present one of them as subject alternative name.
+func buildCluster(clusterName, upstreamHost string, upstreamPort uint32, upstreamTLS bool, http2 bool, upstreamSANs ...string) *cluster.Cluster {
 	cluster := &cluster.Cluster{
 		Name:                 clusterName,
 		ConnectTimeout:       ptypes.DurationProto(5 * time.Second),
@@ -34,7 +59,7 @@ func buildCluster(clusterName, upstreamHost string, upstreamPort uint32, upstrea
 	}
 
 	if upstreamTLS {
-		uTLS := buildUpstreamTLS("client")
+		uTLS := buildUpstreamTLS("client", upstreamSANs...)
 
 		tctx, err := ptypes.MarshalAny(uTLS)
 		if err != nil {
@@ -52,27 +77,113 @@ func buildCluster(clusterName, upstreamHost string, upstreamPort uint32, upstrea
 	return cluster
 }
 
+// buildHostsCluster builds a cluster that load balances over all upstream hosts, unhealthy hosts are found by
+// the active health checks, or the health published through EDS, and ejected by outlier detection. When every host is an IP the cluster is discovered
+// through EDS and the load assignment that has to be added to the snapshot is returned, otherwise the hosts
+// are resolved with STRICT_DNS and no load assignment is returned.
+func buildHostsCluster(clusterName string, upstreamHosts []string, upstreamPort uint32, upstreamTLS bool, http2 bool, healthChecks []*core.HealthCheck, upstreamSANs ...string) (*cluster.Cluster, *endpoint.ClusterLoadAssignment) {
+	c := buildCluster(clusterName, "", upstreamPort, upstreamTLS, http2, upstreamSANs...)
+	assignment := buildLoadAssignment(clusterName, upstreamHosts, upstreamPort)
+
+	c.HealthChecks = healthChecks
+
+	c.OutlierDetection = &cluster.OutlierDetection{
+		Consecutive_5Xx:    wrapperspb.UInt32(outlierConsecutiveErrors),
+		Interval:           ptypes.DurationProto(outlierInterval),
+		BaseEjectionTime:   ptypes.DurationProto(outlierBaseEjectionTime),
+		MaxEjectionPercent: wrapperspb.UInt32(outlierMaxEjectionPercent),
+	}
+
+	for _, host := range upstreamHosts {
+		if net.ParseIP(host) == nil {
+			c.ClusterDiscoveryType = &cluster.Cluster_Type{Type: cluster.Cluster_STRICT_DNS}
+			c.LoadAssignment = assignment
+			return c, nil
+		}
+	}
+
+	c.ClusterDiscoveryType = &cluster.Cluster_Type{Type: cluster.Cluster_EDS}
+	c.LoadAssignment = nil
+	c.EdsClusterConfig = &cluster.Cluster_EdsClusterConfig{
+		EdsConfig: buildConfigSource(),
+	}
+
+	return c, assignment
+}
+
+// buildGRPCHealthCheck builds a gRPC health check of the service, an empty service checks the overall health
+func buildGRPCHealthCheck(config healthCheckConfig, serviceName string) []*core.HealthCheck {
+	if config.disabled {
+		return nil
+	}
+
+	check := buildHealthCheck(config)
+	check.HealthChecker = &core.HealthCheck_GrpcHealthCheck_{
+		GrpcHealthCheck: &core.HealthCheck_GrpcHealthCheck{
+			ServiceName: serviceName,
+		},
+	}
+
+	return []*core.HealthCheck{check}
+}
+
+// buildHTTPHealthCheck builds an HTTP/2 health check of the path, only 2xx responses are healthy
+func buildHTTPHealthCheck(config healthCheckConfig, path string) []*core.HealthCheck {
+	if config.disabled {
+		return nil
+	}
+
+	check := buildHealthCheck(config)
+	check.HealthChecker = &core.HealthCheck_HttpHealthCheck_{
+		HttpHealthCheck: &core.HealthCheck_HttpHealthCheck{
+			Path:            path,
+			CodecClientType: envoytype.CodecClientType_HTTP2,
+		},
+	}
+
+	return []*core.HealthCheck{check}
+}
+
+func buildHealthCheck(config healthCheckConfig) *core.HealthCheck {
+	return &core.HealthCheck{
+		Timeout:            ptypes.DurationProto(config.timeout),
+		Interval:           ptypes.DurationProto(config.interval),
+		UnhealthyThreshold: wrapperspb.UInt32(config.unhealthyThreshold),
+		HealthyThreshold:   wrapperspb.UInt32(config.healthyThreshold),
+	}
+}
+
 func buildEndpoint(clusterName string, upstreamHost string, upstreamPort uint32) *endpoint.ClusterLoadAssignment {
-	return &endpoint.ClusterLoadAssignment{
-		ClusterName: clusterName,
-		Endpoints: []*endpoint.LocalityLbEndpoints{{
-			LbEndpoints: []*endpoint.LbEndpoint{{
-				HostIdentifier: &endpoint.LbEndpoint_Endpoint{
-					Endpoint: &endpoint.Endpoint{
-						Address: &core.Address{
-							Address: &core.Address_SocketAddress{
-								SocketAddress: &core.SocketAddress{
-									Protocol: core.SocketAddress_TCP,
-									Address:  upstreamHost,
-									PortSpecifier: &core.SocketAddress_PortValue{
-										PortValue: upstreamPort,
-									},
+	return buildLoadAssignment(clusterName, []string{upstreamHost}, upstreamPort)
+}
+
+// buildLoadAssignment builds the load assignment of a cluster with an endpoint for each upstream host
+func buildLoadAssignment(clusterName string, upstreamHosts []string, upstreamPort uint32) *endpoint.ClusterLoadAssignment {
+	endpoints := []*endpoint.LbEndpoint{}
+	for _, host := range upstreamHosts {
+		endpoints = append(endpoints, &endpoint.LbEndpoint{
+			HostIdentifier: &endpoint.LbEndpoint_Endpoint{
+				Endpoint: &endpoint.Endpoint{
+					Address: &core.Address{
+						Address: &core.Address_SocketAddress{
+							SocketAddress: &core.SocketAddress{
+								Protocol: core.SocketAddress_TCP,
+								Address:  host,
+								PortSpecifier: &core.SocketAddress_PortValue{
+									PortValue: upstreamPort,
 								},
 							},
 						},
 					},
 				},
-			}},
+			},
+		})
+	}
+
+	return &endpoint.ClusterLoadAssignment{
+		ClusterName: clusterName,
+		Endpoints: []*endpoint.LocalityLbEndpoints{{
+			LbEndpoints: endpoints,
 		}},
 	}
 }
@@ -163,7 +274,9 @@ func buildVirtualHost(name string, domains []string, clusterName string, pathPre
 	return vh
 }
 
-func buildListener(listenerName string, listenerPort uint32, route string, secretName string, clientValidation bool) *listener.Listener {
+// buildListener builds a listener using the http connection manager, when allowedSANs are given
+// clients must present a certificate with one of them as subject alternative name.
+func buildListener(listenerName string, listenerPort uint32, route string, secretName string, clientValidation bool, allowedSANs ...string) *listener.Listener {
 	manager := &hcm.HttpConnectionManager{
 		CodecType:  hcm.HttpConnectionManager_AUTO,
 		StatPrefix: "http",
@@ -196,7 +309,7 @@ func buildListener(listenerName string, listenerPort uint32, route string, secre
 	}
 
 	if secretName != "" {
-		tlsContext := buildDownstreamTLS(secretName, clientValidation)
+		tlsContext := buildDownstreamTLS(secretName, clientValidation, allowedSANs...)
 		scfg, err := ptypes.MarshalAny(tlsContext)
 		if err != nil {
 			panic(err)
@@ -211,18 +324,8 @@ func buildListener(listenerName string, listenerPort uint32, route string, secre
 	}
 
 	listener := &listener.Listener{
-		Name: listenerName,
-		Address: &core.Address{
-			Address: &core.Address_SocketAddress{
-				SocketAddress: &core.SocketAddress{
-					Protocol: core.SocketAddress_TCP,
-					Address:  "0.0.0.0",
-					PortSpecifier: &core.SocketAddress_PortValue{
-						PortValue: listenerPort,
-					},
-				},
-			},
-		},
+		Name:    listenerName,
+		Address: buildListenerAddress(listenerPort),
 		FilterChains: []*listener.FilterChain{
 			filterChain,
 		},
@@ -231,6 +334,140 @@ func buildListener(listenerName string, listenerPort uint32, route string, secre
 	return listener
 }
 
+// buildTCPListener builds a listener that proxies connections to the cluster without looking into them,
+// when allowedSANs are given clients must present a certificate with one of them as subject alternative name.
+func buildTCPListener(listenerName string, listenerPort uint32, clusterName string, secretName string, clientValidation bool, allowedSANs ...string) *listener.Listener {
+	return &listener.Listener{
+		Name:    listenerName,
+		Address: buildListenerAddress(listenerPort),
+		FilterChains: []*listener.FilterChain{
+			buildTCPFilterChain(listenerName, clusterName, secretName, clientValidation, allowedSANs...),
+		},
+	}
+}
+
+// tcpRoute routes the connections that carry the server name to the cluster
+type tcpRoute struct {
+	serverName string
+	cluster    string
+}
+
+// buildSNIListener builds a listener that terminates TLS and proxies each connection to the cluster of the
+// route matching the server name the client sent (SNI), so several TCP services share one port. Connections
+// without a matching server name are closed.
+func buildSNIListener(listenerName string, listenerPort uint32, routes []tcpRoute, secretName string, clientValidation bool, allowedSANs ...string) *listener.Listener {
+	inspector, err := ptypes.MarshalAny(&tlsinspector.TlsInspector{})
+	if err != nil {
+		panic(err)
+	}
+
+	filterChains := []*listener.FilterChain{}
+	for _, r := range routes {
+		filterChain := buildTCPFilterChain(r.cluster, r.cluster, secretName, clientValidation, allowedSANs...)
+		filterChain.FilterChainMatch = &listener.FilterChainMatch{
+			ServerNames: []string{r.serverName},
+		}
+		filterChains = append(filterChains, filterChain)
+	}
+
+	return &listener.Listener{
+		Name:    listenerName,
+		Address: buildListenerAddress(listenerPort),
+		ListenerFilters: []*listener.ListenerFilter{
+			{
+				Name: wellknown.TlsInspector,
+				ConfigType: &listener.ListenerFilter_TypedConfig{
+					TypedConfig: inspector,
+				},
+			},
+		},
+		FilterChains: filterChains,
+	}
+}
+
+// buildTCPFilterChain builds a filter chain that proxies connections to the cluster, TLS is terminated when a
+// secret is given
+func buildTCPFilterChain(statPrefix string, clusterName string, secretName string, clientValidation bool, allowedSANs ...string) *listener.FilterChain {
+	proxy := &tcp.TcpProxy{
+		StatPrefix: statPrefix,
+		ClusterSpecifier: &tcp.TcpProxy_Cluster{
+			Cluster: clusterName,
+		},
+	}
+
+	pbst, err := ptypes.MarshalAny(proxy)
+	if err != nil {
+		panic(err)
+	}
+
+	filterChain := &listener.FilterChain{
+		Filters: []*listener.Filter{
+			{
+				Name: wellknown.TCPProxy,
+				ConfigType: &listener.Filter_TypedConfig{
+					TypedConfig: pbst,
+				},
+			},
+		},
+	}
+
+	if secretName != "" {
+		tlsContext := buildDownstreamTLS(secretName, clientValidation, allowedSANs...)
+		scfg, err := ptypes.MarshalAny(tlsContext)
+		if err != nil {
+			panic(err)
+		}
+
+		filterChain.TransportSocket = &core.TransportSocket{
+			Name: "envoy.transport_sockets.tls",
+			ConfigType: &core.TransportSocket_TypedConfig{
+				TypedConfig: scfg,
+			},
+		}
+	}
+
+	return filterChain
+}
+
+// buildTCPCluster builds a cluster for raw TCP connections over TLS to all upstream hosts, the server name is
+// sent as SNI so the upstream envoy can route the connection.
+func buildTCPCluster(clusterName string, upstreamHosts []string, upstreamPort uint32, serverName string, upstreamSANs ...string) (*cluster.Cluster, *endpoint.ClusterLoadAssignment) {
+	c, assignment := buildHostsCluster(clusterName, upstreamHosts, upstreamPort, false, false, nil)
+
+	uTLS := buildUpstreamTLS("client", upstreamSANs...)
+	uTLS.Sni = serverName
+	// Note: no application protocol is spoken on top of the connection
+	uTLS.CommonTlsContext.AlpnProtocols = nil
+
+	tctx, err := ptypes.MarshalAny(uTLS)
+	if err != nil {
+		panic(err)
+	}
+
+	c.TransportSocket = &core.TransportSocket{
+		Name: "envoy.transport_sockets.tls",
+		ConfigType: &core.TransportSocket_TypedConfig{
+			TypedConfig: tctx,
+		},
+	}
+
+	return c, assignment
+}
+
+func buildListenerAddress(listenerPort uint32) *core.Address {
+	return &core.Address{
+		Address: &core.Address_SocketAddress{
+			SocketAddress: &core.SocketAddress{
+				Protocol: core.SocketAddress_TCP,
+				Address:  "0.0.0.0",
+				PortSpecifier: &core.SocketAddress_PortValue{
+					PortValue: listenerPort,
+				},
+			},
+		},
+	}
+}
+
 func buildConfigSource() *core.ConfigSource {
 	source := &core.ConfigSource{}
 	source.ResourceApiVersion = resource.DefaultAPIVersion
@@ -242,7 +479,7 @@ func buildConfigSource() *core.ConfigSource {
 	return source
 }
 
-func buildDownstreamTLS(secretName string, clientValidation bool) *tlsv3.DownstreamTlsContext {
+func buildDownstreamTLS(secretName string, clientValidation bool, allowedSANs ...string) *tlsv3.DownstreamTlsContext {
 	downstreamTLS := &tlsv3.DownstreamTlsContext{
 		RequireClientCertificate: &wrapperspb.BoolValue{Value: clientValidation},
 		CommonTlsContext: &tlsv3.CommonTlsContext{
@@ -272,11 +509,15 @@ func buildDownstreamTLS(secretName string, clientValidation bool) *tlsv3.Downstr
 		},
 	}
 
+	if len(allowedSANs) > 0 {
+		downstreamTLS.CommonTlsContext.ValidationContextType = buildCombinedValidation(allowedSANs)
+	}
+
 	return downstreamTLS
 }
 
-func buildUpstreamTLS(secretName string) *tls.UpstreamTlsContext {
-	return &tls.UpstreamTlsContext{
+func buildUpstreamTLS(secretName string, allowedSANs ...string) *tls.UpstreamTlsContext {
+	upstreamTLS := &tls.UpstreamTlsContext{
 		CommonTlsContext: &tls.CommonTlsContext{
 			AlpnProtocols: []string{"h2", "http/1.1"},
 			TlsCertificateSdsSecretConfigs: []*tls.SdsSecretConfig{
@@ -303,6 +544,42 @@ func buildUpstreamTLS(secretName string) *tls.UpstreamTlsContext {
 			},
 		},
 	}
+
+	if len(allowedSANs) > 0 {
+		upstreamTLS.CommonTlsContext.ValidationContextType = buildCombinedValidation(allowedSANs)
+	}
+
+	return upstreamTLS
+}
+
+// buildCombinedValidation merges the CA bundle from the validation secret with a subject alternative name
+// match, so that only the given identities are accepted rather than everything signed by the Atlas CA.
+func buildCombinedValidation(allowedSANs []string) *tls.CommonTlsContext_CombinedValidationContext {
+	matchers := []*matcher.StringMatcher{}
+	for _, san := range allowedSANs {
+		matchers = append(matchers, &matcher.StringMatcher{
+			MatchPattern: &matcher.StringMatcher_Exact{
+				Exact: san,
+			},
+		})
+	}
+
+	return &tls.CommonTlsContext_CombinedValidationContext{
+		CombinedValidationContext: &tls.CommonTlsContext_CombinedCertificateValidationContext{
+			DefaultValidationContext: &tls.CertificateValidationContext{
+				MatchSubjectAltNames: matchers,
+			},
+			ValidationContextSdsSecretConfig: &tls.SdsSecretConfig{
+				Name: "validation",
+				SdsConfig: &core.ConfigSource{
+					ConfigSourceSpecifier: &core.ConfigSource_Ads{
+						Ads: &core.AggregatedConfigSource{},
+					},
+					ResourceApiVersion: core.ApiVersion_V3,
+				},
+			},
+		},
+	}
 }
 
 func buildSecretTLSCertificate(name string, cert, key []byte) *tls.Secret {
@@ -321,27 +598,58 @@ func buildSecretTLSCertificate(name string, cert, key []byte) *tls.Secret {
 	}
 }
 
-func buildSecretTLSValidation(name string, ca []byte) *tls.Secret {
+func buildSecretTLSValidation(name string, ca []byte, crl []byte) *tls.Secret {
+	validation := &tls.CertificateValidationContext{
+		TrustedCa: &core.DataSource{
+			Specifier: &core.DataSource_InlineBytes{InlineBytes: []byte(ca)},
+		},
+	}
+
+	if len(crl) > 0 {
+		validation.Crl = &core.DataSource{
+			Specifier: &core.DataSource_InlineBytes{InlineBytes: crl},
+		}
+		onlyVerifyLeafCertCRL(validation)
+	}
+
 	return &tls.Secret{
 		Name: name,
 		Type: &tls.Secret_ValidationContext{
-			ValidationContext: &tls.CertificateValidationContext{
-				TrustedCa: &core.DataSource{
-					Specifier: &core.DataSource_InlineBytes{InlineBytes: []byte(ca)},
-				},
-			},
+			ValidationContext: validation,
 		},
 	}
 }
 
+// onlyVerifyLeafCertCRLField is the field number of only_verify_leaf_cert_crl in CertificateValidationContext
+const onlyVerifyLeafCertCRLField = 14
+
+// onlyVerifyLeafCertCRL has envoy only check the CRL of the issuer of the leaf certificate instead of a CRL
+// for every certificate of the chain, Atlas cannot sign CRLs for the issuer chain of an external CA.
+// Note: the field was added in envoy 1.19 after the go-control-plane release in use, it is set as an unknown
+// field which is marshalled along with the known ones.
+func onlyVerifyLeafCertCRL(validation *tls.CertificateValidationContext) {
+	field := protowire.AppendTag(nil, onlyVerifyLeafCertCRLField, protowire.VarintType)
+	field = protowire.AppendVarint(field, protowire.EncodeBool(true))
+
+	validation.ProtoReflect().SetUnknown(field)
+}
+
+// CombineCAs joins every CA certificate of the CA secret into one bundle, ordered by key so that the
+// bundle and the hash of the snapshot it is part of do not change between syncs
 func CombineCAs(ca *k8scorev1.Secret) []byte {
-	cas := [][]byte{}
-	sep := []byte("\n")
-	for k, v := range ca.Data {
-		if k == "ca-key.pem" {
+	keys := []string{}
+	for k := range ca.Data {
+		if strings.HasPrefix(k, "ca-key") {
 			continue
 		}
-		cas = append(cas, v)
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	cas := [][]byte{}
+	sep := []byte("\n")
+	for _, k := range keys {
+		cas = append(cas, ca.Data[k])
 	}
 	return bytes.Join(cas, sep)
 }
@@ -0,0 +1,83 @@
+package envoy
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	k8scorev1 "k8s.io/api/core/v1"
+)
+
+func TestCombineCAsIsOrderedByKey(t *testing.T) {
+	secret := &k8scorev1.Secret{
+		Data: map[string][]byte{
+			"ca.pem":     []byte("active"),
+			"ca-2.pem":   []byte("second"),
+			"ca-1.pem":   []byte("first"),
+			"ca-key.pem": []byte("key"),
+		},
+	}
+
+	expected := "first\nsecond\nactive"
+	for i := 0; i < 20; i++ {
+		if bundle := string(CombineCAs(secret)); bundle != expected {
+			t.Fatalf("expected bundle %q, got %q", expected, bundle)
+		}
+	}
+}
+
+func TestBuildCombinedValidation(t *testing.T) {
+	validation := buildCombinedValidation([]string{"east.cluster.atlas", "west.cluster.atlas"})
+
+	combined := validation.CombinedValidationContext
+	if name := combined.GetValidationContextSdsSecretConfig().GetName(); name != "validation" {
+		t.Errorf("expected the CA bundle of the validation secret, got %q", name)
+	}
+	expectSANs(t, "validation context", combined.GetDefaultValidationContext().GetMatchSubjectAltNames(), "east.cluster.atlas", "west.cluster.atlas")
+}
+
+// marshalledBool returns the value of a bool field in the wire format of the message
+func marshalledBool(t *testing.T, m proto.Message, number protowire.Number) (bool, bool) {
+	t.Helper()
+
+	data, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		if num == number && typ == protowire.VarintType {
+			v, n := protowire.ConsumeVarint(data)
+			if n < 0 {
+				t.Fatal(protowire.ParseError(n))
+			}
+			return protowire.DecodeBool(v), true
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, data)
+		if n < 0 {
+			t.Fatal(protowire.ParseError(n))
+		}
+		data = data[n:]
+	}
+
+	return false, false
+}
+
+func TestBuildSecretTLSValidationOnlyVerifiesLeafCRL(t *testing.T) {
+	withCRL := buildSecretTLSValidation("validation", []byte("ca"), []byte("crl")).GetValidationContext()
+	if v, ok := marshalledBool(t, withCRL, onlyVerifyLeafCertCRLField); !ok || !v {
+		t.Error("expected only_verify_leaf_cert_crl to be set along with a CRL")
+	}
+
+	withoutCRL := buildSecretTLSValidation("validation", []byte("ca"), nil).GetValidationContext()
+	if _, ok := marshalledBool(t, withoutCRL, onlyVerifyLeafCertCRLField); ok {
+		t.Error("expected only_verify_leaf_cert_crl not to be set without a CRL")
+	}
+}
@@ -0,0 +1,130 @@
+package envoy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	"github.com/goatlas-io/atlas/pkg/common"
+	"github.com/goatlas-io/atlas/pkg/pki"
+)
+
+// streamIdentity is the identity of the peer that opened an ADS stream
+type streamIdentity struct {
+	// authenticated is true when the peer presented a verified client certificate
+	authenticated bool
+	// names are the subject alternative names (or common name) of the client certificate
+	names []string
+	// notAfter is the expiry of the client certificate
+	notAfter time.Time
+}
+
+// identityFromContext extracts the verified client certificate identity from the grpc stream context
+func identityFromContext(ctx context.Context) streamIdentity {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.AuthInfo == nil {
+		return streamIdentity{}
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.PeerCertificates) == 0 {
+		return streamIdentity{}
+	}
+
+	cert := tlsInfo.State.PeerCertificates[0]
+
+	names := cert.DNSNames
+	if len(names) == 0 && cert.Subject.CommonName != "" {
+		// Note: certificates issued before subject alternative names were added only carry the common name
+		names = []string{cert.Subject.CommonName}
+	}
+
+	return streamIdentity{
+		authenticated: true,
+		names:         names,
+		notAfter:      cert.NotAfter,
+	}
+}
+
+// allowsNode returns true when the identity is allowed to request resources for the node id.
+// Unauthenticated streams may only request the observability node, these come from the
+// in-cluster plaintext port.
+func (i streamIdentity) allowsNode(nodeID string) bool {
+	if !i.authenticated {
+		return nodeID == common.EnvoyADSObservabilityID
+	}
+
+	for _, name := range i.names {
+		if name == common.ServerCommonName || name == common.ClientCommonName {
+			if nodeID == common.EnvoyADSObservabilityID {
+				return true
+			}
+			continue
+		}
+
+		if cluster, ok := common.ClusterFromNodeID(nodeID); ok && name == fmt.Sprintf(common.ClusterCommonNameFormat, cluster) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tlsConfig builds the mTLS configuration of the grpc server, it is resolved on every handshake
+// so that rotated certificates and CAs are picked up without a restart.
+func (e *EnvoyADS) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			ca, err := e.secretsCache.Get(e.namespace, common.CASecretName)
+			if err != nil {
+				return nil, err
+			}
+
+			server, err := e.secretsCache.Get(e.namespace, common.ServerSecretName)
+			if err != nil {
+				return nil, err
+			}
+
+			cert, err := tls.X509KeyPair(server.Data["tls.crt"], server.Data["tls.key"])
+			if err != nil {
+				return nil, err
+			}
+
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(CombineCAs(ca)) {
+				return nil, fmt.Errorf("unable to load certificate authorities")
+			}
+
+			revoked, err := pki.Revoked(ca)
+			if err != nil {
+				return nil, err
+			}
+
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{cert},
+				ClientCAs:    pool,
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				NextProtos:   []string{"h2"},
+				VerifyPeerCertificate: func(_ [][]byte, chains [][]*x509.Certificate) error {
+					for _, chain := range chains {
+						if len(chain) == 0 {
+							continue
+						}
+						if _, ok := revoked[chain[0].SerialNumber.String()]; ok {
+							rejectedStreams.WithLabelValues("revoked").Inc()
+							return fmt.Errorf("client certificate %s has been revoked", chain[0].SerialNumber)
+						}
+					}
+					return nil
+				},
+			}, nil
+		},
+	}
+}
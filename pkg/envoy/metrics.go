@@ -12,11 +12,71 @@ var (
 	})
 	snapshots = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "atlas_envoy_ads_snapshots_total",
-		Help: "The number of snapshots generated for Envoy ADS server",
+		Help: "The number of snapshots set in the cache of the Envoy ADS server, unchanged snapshots are not set",
 	})
+	snapshotUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "atlas_envoy_ads_snapshot_updates_total",
+		Help: "The number of generated snapshots that changed and were published or were unchanged and suppressed",
+	}, []string{"result"})
+	rejectedStreams = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "atlas_envoy_ads_rejected_streams_total",
+		Help: "The number of streams and fetches rejected because the node id did not match the client identity or the client certificate was revoked",
+	}, []string{"reason"})
+	clientCertificateNotAfter = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "atlas_envoy_ads_client_certificate_not_after_timestamp_seconds",
+		Help: "The unix timestamp at which the client certificate a connected Envoy Proxy authenticated with expires by node and cluster",
+	}, []string{"node", "cluster"})
+	syncQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "atlas_envoy_ads_sync_queue_depth",
+		Help: "The number of change events waiting for the next sync",
+	})
+	syncCoalescedEvents = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "atlas_envoy_ads_sync_coalesced_events_total",
+		Help: "The number of change events that were merged into an already pending sync",
+	})
+	syncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "atlas_envoy_ads_sync_duration_seconds",
+		Help: "How long it took to regenerate the snapshots by scope of the sync",
+	}, []string{"scope"})
+	resourcesSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "atlas_envoy_ads_resources_sent_total",
+		Help: "The number of resources sent to Envoy Proxies by xDS protocol and type",
+	}, []string{"protocol", "type"})
+	configNacks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "atlas_envoy_ads_config_nacks_total",
+		Help: "The number of responses rejected by connected Envoy Proxies by node and type",
+	}, []string{"node", "type"})
+	configInSync = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "atlas_envoy_ads_config_in_sync",
+		Help: "Whether a connected Envoy Proxy acknowledged the last version sent to it by node and type",
+	}, []string{"node", "type"})
+	configLastAck = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "atlas_envoy_ads_config_last_ack_timestamp_seconds",
+		Help: "The unix timestamp at which a connected Envoy Proxy last acknowledged a response by node and type",
+	}, []string{"node", "type"})
+	upstreamEndpointHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "atlas_envoy_ads_upstream_endpoint_healthy",
+		Help: "Whether the observability Envoy Proxy reports an endpoint of a downstream cluster upstream as healthy",
+	}, []string{"cluster", "upstream", "address"})
+	upstreamHealthyEndpoints = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "atlas_envoy_ads_upstream_healthy_endpoints",
+		Help: "The number of endpoints of a downstream cluster upstream the observability Envoy Proxy reports as healthy",
+	}, []string{"cluster", "upstream"})
 )
 
 func init() {
 	metrics.EnvoyAdsRegistry.MustRegister(connectedClients)
 	metrics.EnvoyAdsRegistry.MustRegister(snapshots)
+	metrics.EnvoyAdsRegistry.MustRegister(snapshotUpdates)
+	metrics.EnvoyAdsRegistry.MustRegister(rejectedStreams)
+	metrics.EnvoyAdsRegistry.MustRegister(clientCertificateNotAfter)
+	metrics.EnvoyAdsRegistry.MustRegister(syncQueueDepth)
+	metrics.EnvoyAdsRegistry.MustRegister(syncCoalescedEvents)
+	metrics.EnvoyAdsRegistry.MustRegister(syncDuration)
+	metrics.EnvoyAdsRegistry.MustRegister(resourcesSent)
+	metrics.EnvoyAdsRegistry.MustRegister(configNacks)
+	metrics.EnvoyAdsRegistry.MustRegister(configInSync)
+	metrics.EnvoyAdsRegistry.MustRegister(configLastAck)
+	metrics.EnvoyAdsRegistry.MustRegister(upstreamEndpointHealthy)
+	metrics.EnvoyAdsRegistry.MustRegister(upstreamHealthyEndpoints)
 }
@@ -0,0 +1,374 @@
+package envoy
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	k8scorev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	toolscache "k8s.io/client-go/tools/cache"
+
+	"github.com/goatlas-io/atlas/pkg/apis/atlas.goatlas.io/v1alpha1"
+	"github.com/goatlas-io/atlas/pkg/config"
+	atlascontrollers "github.com/goatlas-io/atlas/pkg/generated/controllers/atlas.goatlas.io/v1alpha1"
+	wranglercorev1 "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
+)
+
+// Render generates the snapshots of every node from the given services, secrets and atlas clusters without
+// access to a cluster, the same way the ADS server does, and returns them in order of node ids. Objects
+// without a namespace are placed in the namespace of the cli context.
+func Render(config *config.EnvoyADSConfig, log *logrus.Entry, cliCtx *cli.Context, objects []runtime.Object, versionID string, redact bool) ([]*SnapshotView, error) {
+	ads, err := newOfflineADS(config, log, cliCtx, objects)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ads.sync(versionID); err != nil {
+		return nil, err
+	}
+
+	nodes := []string{}
+	for nodeID := range ads.snapshotHashes {
+		nodes = append(nodes, nodeID)
+	}
+	sort.Strings(nodes)
+
+	views := []*SnapshotView{}
+	for _, nodeID := range nodes {
+		snapshot, err := ads.cache.GetSnapshot(nodeID)
+		if err != nil {
+			return nil, err
+		}
+
+		view, err := RenderSnapshot(nodeID, snapshot, redact)
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, view)
+	}
+
+	return views, nil
+}
+
+// newOfflineADS builds an ADS server whose caches are backed by the given objects instead of informers
+func newOfflineADS(config *config.EnvoyADSConfig, log *logrus.Entry, cliCtx *cli.Context, objects []runtime.Object) (*EnvoyADS, error) {
+	namespace := cliCtx.String("namespace")
+
+	services := newIndexer()
+	secrets := newIndexer()
+	atlasClusters := newIndexer()
+
+	for _, obj := range objects {
+		m, err := meta.Accessor(obj)
+		if err != nil {
+			return nil, err
+		}
+		if m.GetNamespace() == "" {
+			m.SetNamespace(namespace)
+		}
+
+		switch obj.(type) {
+		case *k8scorev1.Service:
+			err = services.Add(obj)
+		case *k8scorev1.Secret:
+			err = secrets.Add(obj)
+		case *v1alpha1.AtlasCluster:
+			err = atlasClusters.Add(obj)
+		default:
+			err = fmt.Errorf("unsupported object %T", obj)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &EnvoyADS{
+		servicesCache:      &offlineServiceCache{indexer: services},
+		secretsCache:       &offlineSecretCache{indexer: secrets},
+		atlasClustersCache: &offlineAtlasClusterCache{indexer: atlasClusters},
+		clusterGenerations: map[string]int64{},
+		snapshotHashes:     map[string]snapshotHash{},
+		cache:              cache.NewSnapshotCache(false, cache.IDHash{}, nil),
+		config:             config,
+		log:                log,
+		cli:                cliCtx,
+		namespace:          namespace,
+	}, nil
+}
+
+// ReadManifests reads the services, secrets and atlas clusters from YAML or JSON manifests, directories are
+// read without descending into subdirectories and - reads from stdin. Lists are expanded and objects of
+// other kinds are skipped.
+func ReadManifests(paths ...string) ([]runtime.Object, error) {
+	objects := []runtime.Object{}
+
+	for _, path := range paths {
+		files := []string{path}
+
+		if path != "-" {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+
+			if info.IsDir() {
+				entries, err := ioutil.ReadDir(path)
+				if err != nil {
+					return nil, err
+				}
+
+				files = []string{}
+				for _, entry := range entries {
+					ext := filepath.Ext(entry.Name())
+					if entry.IsDir() || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+						continue
+					}
+					files = append(files, filepath.Join(path, entry.Name()))
+				}
+			}
+		}
+
+		for _, file := range files {
+			objs, err := readManifest(file)
+			if err != nil {
+				return nil, fmt.Errorf("unable to read %s: %w", file, err)
+			}
+			objects = append(objects, objs...)
+		}
+	}
+
+	return objects, nil
+}
+
+func readManifest(file string) ([]runtime.Object, error) {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	objects := []runtime.Object{}
+
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		// Note: empty documents between separators decode to nothing
+		if len(obj.Object) == 0 {
+			continue
+		}
+
+		items := []unstructured.Unstructured{*obj}
+		if obj.IsList() {
+			list, err := obj.ToList()
+			if err != nil {
+				return nil, err
+			}
+			items = list.Items
+		}
+
+		for i := range items {
+			typed, err := fromUnstructured(&items[i])
+			if err != nil {
+				return nil, err
+			}
+			if typed != nil {
+				objects = append(objects, typed)
+			}
+		}
+	}
+
+	return objects, nil
+}
+
+// fromUnstructured converts the object to its type, nil is returned for kinds that are not rendered
+func fromUnstructured(obj *unstructured.Unstructured) (runtime.Object, error) {
+	var typed runtime.Object
+
+	gvk := obj.GroupVersionKind()
+	switch {
+	case gvk.Group == "" && gvk.Kind == "Service":
+		typed = &k8scorev1.Service{}
+	case gvk.Group == "" && gvk.Kind == "Secret":
+		typed = &k8scorev1.Secret{}
+	case gvk.Group == v1alpha1.SchemeGroupVersion.Group && gvk.Kind == "AtlasCluster":
+		typed = &v1alpha1.AtlasCluster{}
+	default:
+		return nil, nil
+	}
+
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, typed); err != nil {
+		return nil, fmt.Errorf("unable to convert %s %s: %w", gvk.Kind, obj.GetName(), err)
+	}
+
+	// Note: the api server merges string data into the data of a secret, manifests may still carry it
+	if secret, ok := typed.(*k8scorev1.Secret); ok && len(secret.StringData) > 0 {
+		if secret.Data == nil {
+			secret.Data = map[string][]byte{}
+		}
+		for k, v := range secret.StringData {
+			secret.Data[k] = []byte(v)
+		}
+		secret.StringData = nil
+	}
+
+	return typed, nil
+}
+
+func newIndexer() toolscache.Indexer {
+	return toolscache.NewIndexer(toolscache.MetaNamespaceKeyFunc, toolscache.Indexers{
+		toolscache.NamespaceIndex: toolscache.MetaNamespaceIndexFunc,
+	})
+}
+
+// offlineServiceCache serves services read from manifests in place of the informer cache
+type offlineServiceCache struct {
+	indexer toolscache.Indexer
+}
+
+func (c *offlineServiceCache) Get(namespace, name string) (*k8scorev1.Service, error) {
+	obj, exists, err := c.indexer.GetByKey(namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, apierrors.NewNotFound(k8scorev1.Resource("services"), name)
+	}
+	return obj.(*k8scorev1.Service), nil
+}
+
+func (c *offlineServiceCache) List(namespace string, selector labels.Selector) (ret []*k8scorev1.Service, err error) {
+	err = toolscache.ListAllByNamespace(c.indexer, namespace, selector, func(m interface{}) {
+		ret = append(ret, m.(*k8scorev1.Service))
+	})
+	return ret, err
+}
+
+func (c *offlineServiceCache) AddIndexer(indexName string, indexer wranglercorev1.ServiceIndexer) {
+	utilruntime.Must(c.indexer.AddIndexers(map[string]toolscache.IndexFunc{
+		indexName: func(obj interface{}) ([]string, error) {
+			return indexer(obj.(*k8scorev1.Service))
+		},
+	}))
+}
+
+func (c *offlineServiceCache) GetByIndex(indexName, key string) ([]*k8scorev1.Service, error) {
+	objs, err := c.indexer.ByIndex(indexName, key)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*k8scorev1.Service, 0, len(objs))
+	for _, obj := range objs {
+		result = append(result, obj.(*k8scorev1.Service))
+	}
+	return result, nil
+}
+
+// offlineSecretCache serves secrets read from manifests in place of the informer cache
+type offlineSecretCache struct {
+	indexer toolscache.Indexer
+}
+
+func (c *offlineSecretCache) Get(namespace, name string) (*k8scorev1.Secret, error) {
+	obj, exists, err := c.indexer.GetByKey(namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, apierrors.NewNotFound(k8scorev1.Resource("secrets"), name)
+	}
+	return obj.(*k8scorev1.Secret), nil
+}
+
+func (c *offlineSecretCache) List(namespace string, selector labels.Selector) (ret []*k8scorev1.Secret, err error) {
+	err = toolscache.ListAllByNamespace(c.indexer, namespace, selector, func(m interface{}) {
+		ret = append(ret, m.(*k8scorev1.Secret))
+	})
+	return ret, err
+}
+
+func (c *offlineSecretCache) AddIndexer(indexName string, indexer wranglercorev1.SecretIndexer) {
+	utilruntime.Must(c.indexer.AddIndexers(map[string]toolscache.IndexFunc{
+		indexName: func(obj interface{}) ([]string, error) {
+			return indexer(obj.(*k8scorev1.Secret))
+		},
+	}))
+}
+
+func (c *offlineSecretCache) GetByIndex(indexName, key string) ([]*k8scorev1.Secret, error) {
+	objs, err := c.indexer.ByIndex(indexName, key)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*k8scorev1.Secret, 0, len(objs))
+	for _, obj := range objs {
+		result = append(result, obj.(*k8scorev1.Secret))
+	}
+	return result, nil
+}
+
+// offlineAtlasClusterCache serves atlas clusters read from manifests in place of the informer cache
+type offlineAtlasClusterCache struct {
+	indexer toolscache.Indexer
+}
+
+func (c *offlineAtlasClusterCache) Get(namespace, name string) (*v1alpha1.AtlasCluster, error) {
+	obj, exists, err := c.indexer.GetByKey(namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, apierrors.NewNotFound(v1alpha1.Resource(v1alpha1.AtlasClusterResourceName), name)
+	}
+	return obj.(*v1alpha1.AtlasCluster), nil
+}
+
+func (c *offlineAtlasClusterCache) List(namespace string, selector labels.Selector) (ret []*v1alpha1.AtlasCluster, err error) {
+	err = toolscache.ListAllByNamespace(c.indexer, namespace, selector, func(m interface{}) {
+		ret = append(ret, m.(*v1alpha1.AtlasCluster))
+	})
+	return ret, err
+}
+
+func (c *offlineAtlasClusterCache) AddIndexer(indexName string, indexer atlascontrollers.AtlasClusterIndexer) {
+	utilruntime.Must(c.indexer.AddIndexers(map[string]toolscache.IndexFunc{
+		indexName: func(obj interface{}) ([]string, error) {
+			return indexer(obj.(*v1alpha1.AtlasCluster))
+		},
+	}))
+}
+
+func (c *offlineAtlasClusterCache) GetByIndex(indexName, key string) ([]*v1alpha1.AtlasCluster, error) {
+	objs, err := c.indexer.ByIndex(indexName, key)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*v1alpha1.AtlasCluster, 0, len(objs))
+	for _, obj := range objs {
+		result = append(result, obj.(*v1alpha1.AtlasCluster))
+	}
+	return result, nil
+}
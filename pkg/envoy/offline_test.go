@@ -0,0 +1,60 @@
+package envoy
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/goatlas-io/atlas/pkg/config"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// TestRenderGolden renders the manifests in testdata the way envoy-render does, so the offline rendering
+// cannot drift from the snapshots the ADS server publishes. Run with -update after an intended change.
+func TestRenderGolden(t *testing.T) {
+	objects, err := ReadManifests("testdata/manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := logrus.New()
+	log.SetLevel(logrus.ErrorLevel)
+
+	conf := config.NewEnvoyADSConfig()
+	conf.AtlasEnvoyAddress = "envoy.atlas.local"
+
+	views, err := Render(conf, logrus.NewEntry(log), newTestCLIContext(), objects, "v.0", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	for _, view := range views {
+		data, err := MarshalView(view, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out.WriteString("---\n")
+		out.Write(data)
+	}
+
+	golden := "testdata/render.golden.yaml"
+	if *update {
+		if err := ioutil.WriteFile(golden, out.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out.Bytes(), expected) {
+		t.Errorf("rendered snapshots differ from %s, run the test with -update if the change is intended\n%s", golden, out.String())
+	}
+}
@@ -0,0 +1,80 @@
+package envoy
+
+import (
+	"sync"
+)
+
+// syncQueue coalesces change events into a single pending sync, a full sync supersedes the syncs
+// of individual clusters
+type syncQueue struct {
+	mu       sync.Mutex
+	full     bool
+	clusters map[string]struct{}
+	pending  int
+
+	// ready is signalled when the first event of a new sync is added
+	ready chan struct{}
+}
+
+func newSyncQueue() *syncQueue {
+	return &syncQueue{
+		clusters: map[string]struct{}{},
+		ready:    make(chan struct{}, 1),
+	}
+}
+
+// addFull requests a sync of every snapshot
+func (q *syncQueue) addFull() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.full = true
+	q.added()
+}
+
+// addClusters requests a sync of the observability snapshot and the snapshots of the named clusters,
+// an empty name only requests the observability snapshot
+func (q *syncQueue) addClusters(names ...string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, name := range names {
+		q.clusters[name] = struct{}{}
+	}
+	q.added()
+}
+
+// added records an event, must be called with mu held
+func (q *syncQueue) added() {
+	q.pending++
+	syncQueueDepth.Set(float64(q.pending))
+
+	if q.pending > 1 {
+		syncCoalescedEvents.Inc()
+		return
+	}
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the pending sync and empties the queue
+func (q *syncQueue) take() (bool, []string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	full := q.full
+	clusters := []string{}
+	for name := range q.clusters {
+		clusters = append(clusters, name)
+	}
+
+	q.full = false
+	q.clusters = map[string]struct{}{}
+	q.pending = 0
+	syncQueueDepth.Set(0)
+
+	return full, clusters
+}
@@ -0,0 +1,102 @@
+package envoy
+
+import (
+	"encoding/json"
+	"sort"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	tls "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"sigs.k8s.io/yaml"
+)
+
+// redacted replaces the private keys of rendered secrets
+const redacted = "<redacted>"
+
+// SnapshotView is the readable form of the snapshot of a node
+type SnapshotView struct {
+	Node      string       `json:"node"`
+	Endpoints ResourceView `json:"endpoints"`
+	Clusters  ResourceView `json:"clusters"`
+	Routes    ResourceView `json:"routes"`
+	Listeners ResourceView `json:"listeners"`
+	Secrets   ResourceView `json:"secrets"`
+}
+
+// ResourceView holds the resources of one type of a snapshot in order of their names
+type ResourceView struct {
+	Version   string            `json:"version"`
+	Resources []json.RawMessage `json:"resources"`
+}
+
+// RenderSnapshot converts the snapshot of a node to its readable form, the private keys of secrets are
+// replaced when redact is set
+func RenderSnapshot(nodeID string, snapshot cache.Snapshot, redact bool) (*SnapshotView, error) {
+	view := &SnapshotView{Node: nodeID}
+
+	for typ, rv := range map[types.ResponseType]*ResourceView{
+		types.Endpoint: &view.Endpoints,
+		types.Cluster:  &view.Clusters,
+		types.Route:    &view.Routes,
+		types.Listener: &view.Listeners,
+		types.Secret:   &view.Secrets,
+	} {
+		resources := snapshot.Resources[typ]
+
+		names := []string{}
+		for name := range resources.Items {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		rv.Version = resources.Version
+		rv.Resources = []json.RawMessage{}
+		for _, name := range names {
+			resource := resources.Items[name].Resource
+			if secret, ok := resource.(*tls.Secret); ok && redact {
+				resource = redactSecret(secret)
+			}
+
+			data, err := protojson.Marshal(proto.MessageV2(resource))
+			if err != nil {
+				return nil, err
+			}
+			rv.Resources = append(rv.Resources, data)
+		}
+	}
+
+	return view, nil
+}
+
+// redactSecret returns a copy of the secret without its private key, the secrets of the snapshot are
+// shared with the cache and must not be modified
+func redactSecret(secret *tls.Secret) *tls.Secret {
+	certificate := secret.GetTlsCertificate()
+	if certificate.GetPrivateKey() == nil {
+		return secret
+	}
+
+	secret = proto.Clone(secret).(*tls.Secret)
+	secret.GetTlsCertificate().PrivateKey = &core.DataSource{
+		Specifier: &core.DataSource_InlineString{InlineString: redacted},
+	}
+
+	return secret
+}
+
+// MarshalView encodes a view as indented JSON or, when asYAML is set, as YAML
+func MarshalView(view interface{}, asYAML bool) ([]byte, error) {
+	data, err := json.MarshalIndent(view, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if !asYAML {
+		return data, nil
+	}
+
+	return yaml.JSONToYAML(data)
+}
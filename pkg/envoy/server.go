@@ -4,24 +4,32 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/bwmarrin/snowflake"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
+	"github.com/goatlas-io/atlas/pkg/apis/atlas.goatlas.io/v1alpha1"
+	"github.com/goatlas-io/atlas/pkg/clusterstatus"
 	"github.com/goatlas-io/atlas/pkg/common"
 	"github.com/goatlas-io/atlas/pkg/config"
+	atlascontrollers "github.com/goatlas-io/atlas/pkg/generated/controllers/atlas.goatlas.io/v1alpha1"
 
 	"github.com/rancher/wrangler/pkg/apply"
 	wranglercorev1 "github.com/rancher/wrangler/pkg/generated/controllers/core/v1"
+	"github.com/rancher/wrangler/pkg/kv"
 
 	clusterservice "github.com/envoyproxy/go-control-plane/envoy/service/cluster/v3"
 	discoverygrpc "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	endpointservice "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	healthservice "github.com/envoyproxy/go-control-plane/envoy/service/health/v3"
 	listenerservice "github.com/envoyproxy/go-control-plane/envoy/service/listener/v3"
 	routeservice "github.com/envoyproxy/go-control-plane/envoy/service/route/v3"
 	runtimeservice "github.com/envoyproxy/go-control-plane/envoy/service/runtime/v3"
@@ -30,30 +38,42 @@ import (
 	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	rest "github.com/envoyproxy/go-control-plane/pkg/server/rest/v3"
+	sotw "github.com/envoyproxy/go-control-plane/pkg/server/sotw/v3"
 	server "github.com/envoyproxy/go-control-plane/pkg/server/v3"
 
 	k8scorev1 "k8s.io/api/core/v1"
-	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
-	"k8s.io/apimachinery/pkg/selection"
 )
 
 type atlasCluster struct {
 	Name      string
 	Namespace string
 	Replicas  int
-	IP        string
+	// Addresses are the IPs or DNS names the envoy of the cluster is reachable on
+	Addresses []string
 
-	ThanosService string
-	ThanosPort    uint32
+	// ThanosService, PrometheusService and AlertManagerService are where the components are reached on the
+	// downstream cluster, the ports the downstream envoy listens on for them are ThanosPort, PromPort and AMPort
+	ThanosService     string
+	ThanosServicePort uint32
+	ThanosPort        uint32
 
-	PrometheusService string
-	PromPort          uint32
+	PrometheusService     string
+	PrometheusServicePort uint32
+	PromPort              uint32
 
-	AlertManagerService string
-	AMPort              uint32
+	AlertManagerService     string
+	AlertManagerServicePort uint32
+	AMPort                  uint32
 
-	service *k8scorev1.Service
+	// HealthCheck holds the active health check settings of the thanos and prometheus upstreams
+	HealthCheck healthCheckConfig
+
+	// Services are the additional services of the cluster exposed to the observability cluster
+	Services []exposedService
+
+	cluster *v1alpha1.AtlasCluster
 }
 
 type EnvoyADS struct {
@@ -74,6 +94,25 @@ type EnvoyADS struct {
 	secrets       wranglercorev1.SecretController
 	secretsCache  wranglercorev1.SecretCache
 
+	atlasClusters      atlascontrollers.AtlasClusterController
+	atlasClustersCache atlascontrollers.AtlasClusterCache
+
+	recorder       *clusterstatus.Recorder
+	nodeStatusLock sync.Mutex
+
+	// clusterGenerations tracks the last generation of each AtlasCluster that was synced
+	// so that status only updates do not trigger a new sync
+	clusterGenerations map[string]int64
+
+	// snapshotHashes holds the hash of the resources of the last snapshot set for each node
+	snapshotHashes map[string]snapshotHash
+
+	// queue coalesces the syncs requested by change events
+	queue *syncQueue
+
+	// hds assigns the health checks of the downstream clusters to the observability envoy
+	hds *healthDiscoveryServer
+
 	namespace string
 }
 
@@ -84,7 +123,9 @@ func Register(
 	apply apply.Apply,
 	cliCtx *cli.Context,
 	services wranglercorev1.ServiceController,
-	secrets wranglercorev1.SecretController) *EnvoyADS {
+	secrets wranglercorev1.SecretController,
+	atlasClusters atlascontrollers.AtlasClusterController,
+	recorder *clusterstatus.Recorder) *EnvoyADS {
 
 	ads := &EnvoyADS{
 		grpcMaxConcurrentStreams: 1000000,
@@ -92,12 +133,33 @@ func Register(
 		servicesCache:            services.Cache(),
 		secrets:                  secrets,
 		secretsCache:             secrets.Cache(),
+		atlasClusters:            atlasClusters,
+		atlasClustersCache:       atlasClusters.Cache(),
+		clusterGenerations:       map[string]int64{},
+		snapshotHashes:           map[string]snapshotHash{},
+		queue:                    newSyncQueue(),
+		recorder:                 recorder,
 		config:                   config,
 		log:                      log,
 		apply:                    apply,
 		cli:                      cliCtx,
 		debugEnvoy:               false,
 		namespace:                cliCtx.String("namespace"),
+		cache:                    cache.NewSnapshotCache(true, cache.IDHash{}, nil),
+	}
+
+	// Note: the cache and callbacks are created up front so the debug endpoints can be served before leader election
+	ads.hds = newHealthDiscoveryServer(log.WithField("component", "hds"), config.GRPCTLSPort > 0)
+	ads.hds.onHealthChanged = func() { ads.queue.addClusters("") }
+
+	ads.callbacks = &Callbacks{
+		log:           log.WithField("component", "ads-callbacks"),
+		streams:       map[int64]string{},
+		nodeStreams:   map[string]int{},
+		onNodeChanged: ads.nodeConnectionChanged,
+		verifyNodes:   config.GRPCTLSPort > 0,
+		identities:    map[int64]streamIdentity{},
+		configStatus:  map[string]map[string]*ConfigStatus{},
 	}
 
 	return ads
@@ -110,12 +172,10 @@ func (e *EnvoyADS) Start(ctx context.Context, port int, instance int64, debugEnv
 	}
 	e.node = node
 
-	cb := &Callbacks{
-		log: e.log.WithField("component", "ads-callbacks"),
-	}
+	cb := e.callbacks
 
-	e.cache = cache.NewSnapshotCache(true, cache.IDHash{}, nil)
-	e.server = server.NewServer(ctx, e.cache, cb)
+	// Note: the delta server of go-control-plane does not handle streams yet, incremental xDS is served by our own
+	e.server = server.NewServerAdvanced(rest.NewServer(e.cache, cb), sotw.NewServer(ctx, e.cache, cb), newDeltaServer(ctx, e.cache, cb))
 	e.debugEnvoy = debugEnvoy
 
 	e.log.Info("Starting Envoy ADS Server")
@@ -124,10 +184,22 @@ func (e *EnvoyADS) Start(ctx context.Context, port int, instance int64, debugEnv
 		e.log.WithError(err).Error("unable to sync")
 	}
 
+	go e.runSyncQueue(ctx)
+
 	e.secrets.OnChange(ctx, "envoy-ads", e.secretOnChange)
 	e.services.OnChange(ctx, "envoy-ads", e.serviceOnChange)
+	e.atlasClusters.OnChange(ctx, "envoy-ads", e.atlasClusterOnChange)
 
-	go e.RunServer(ctx, e.log, e.server, port)
+	// Note: the plaintext port does not authenticate its peers and hands out private keys, it is bound to
+	// localhost by default. When the mTLS port is enabled, node ids are verified against the client certificate
+	// and the plaintext port is only allowed to serve the observability cluster envoy.
+	go e.RunServer(ctx, e.log, e.server, e.config.GRPCAddress, port, nil)
+
+	if e.config.GRPCTLSPort > 0 {
+		go e.RunServer(ctx, e.log, e.server, "", e.config.GRPCTLSPort, credentials.NewTLS(e.tlsConfig()))
+	} else {
+		e.log.Warn("mTLS port is disabled, node ids of envoy proxies are not verified")
+	}
 
 	<-ctx.Done()
 
@@ -143,42 +215,149 @@ func (e *EnvoyADS) secretOnChange(key string, secret *k8scorev1.Secret) (*k8scor
 
 	labels := secret.GetLabels()
 	if _, ok := labels[common.IsCALabel]; ok {
-		if err := e.Sync(); err != nil {
-			e.log.WithError(err).Error("unable to sync resources")
-		}
+		e.queue.addFull()
 	}
 
+	if _, ok := labels[common.IsCRLLabel]; ok {
+		e.queue.addFull()
+	}
+
+	// Note: a cluster certificate is only used by the envoy of that cluster, the shared
+	// certificates are only used by the observability cluster envoy
 	if _, ok := labels[common.IsCertLabel]; ok {
-		if err := e.Sync(); err != nil {
-			e.log.WithError(err).Error("unable to sync resources")
-		}
+		e.queue.addClusters(labels[common.CAClusterLabel])
 	}
 
 	return secret, nil
 }
 
+// revocationList returns the CRLs published by the controller, if there are no revoked
+// certificates there is no CRL secret and envoy does not check revocation
+func (e *EnvoyADS) revocationList() []byte {
+	crl, err := e.secretsCache.Get(e.namespace, common.CRLSecretName)
+	if err != nil {
+		return nil
+	}
+
+	return crl.Data["crl.pem"]
+}
+
+func (e *EnvoyADS) atlasClusterOnChange(key string, cluster *v1alpha1.AtlasCluster) (*v1alpha1.AtlasCluster, error) {
+	if cluster == nil {
+		e.lock.Lock()
+		delete(e.clusterGenerations, key)
+		e.lock.Unlock()
+
+		_, name := kv.RSplit(key, "/")
+		e.queue.addClusters(name)
+
+		return nil, nil
+	}
+
+	e.lock.Lock()
+	generation, ok := e.clusterGenerations[key]
+	e.clusterGenerations[key] = cluster.Generation
+	e.lock.Unlock()
+
+	// Status updates do not change the generation, there is nothing to sync for those.
+	if ok && generation == cluster.Generation {
+		return cluster, nil
+	}
+
+	e.queue.addClusters(cluster.Name)
+
+	return cluster, nil
+}
+
+// serviceOnChange queues a full sync when an alertmanager service changes, every snapshot routes to them
 func (e *EnvoyADS) serviceOnChange(key string, service *k8scorev1.Service) (*k8scorev1.Service, error) {
 	if service == nil {
-		if strings.Contains(key, e.namespace) {
-			if err := e.Sync(); err != nil {
-				e.log.WithError(err).Error("unable to sync")
+		e.queue.addFull()
+		return nil, nil
+	}
+
+	if service.GetNamespace() != common.MonitoringNamespace && service.GetNamespace() != e.namespace {
+		return service, nil
+	}
+
+	selector, err := labels.Parse(e.cli.String("alertmanager-selector"))
+	if err != nil {
+		return service, err
+	}
+
+	if selector.Matches(labels.Set(service.GetLabels())) {
+		e.queue.addFull()
+	}
+
+	return service, nil
+}
+
+// runSyncQueue performs the queued syncs, events that arrive within the sync window of the first
+// one are coalesced into a single sync. It blocks until the context is done.
+func (e *EnvoyADS) runSyncQueue(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.queue.ready:
+		}
+
+		if e.config.SyncWindow > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(e.config.SyncWindow):
 			}
 		}
 
-		return nil, nil
-	}
+		full, clusters := e.queue.take()
 
-	labels := service.GetLabels()
-	if _, ok := labels[common.AtlasClusterLabel]; ok {
-		if err := e.Sync(); err != nil {
-			e.log.WithError(err).Error("unable to sync")
+		start := time.Now()
+		scope := "clusters"
+
+		var err error
+		if full {
+			scope = "full"
+			err = e.Sync()
+		} else {
+			err = e.SyncCluster(clusters...)
 		}
+
+		syncDuration.WithLabelValues(scope).Observe(time.Since(start).Seconds())
+
+		if err != nil {
+			e.log.WithError(err).WithField("scope", scope).Error("unable to sync resources")
+		}
+	}
+}
+
+// nodeConnectionChanged records whether the envoy of a downstream cluster is connected
+func (e *EnvoyADS) nodeConnectionChanged(nodeID string) {
+	name, ok := common.ClusterFromNodeID(nodeID)
+	if !ok {
+		return
 	}
 
-	return service, nil
+	// Note: serialized so the last write always reflects the current connection state
+	e.nodeStatusLock.Lock()
+	defer e.nodeStatusLock.Unlock()
+
+	var err error
+	reason := "StreamOpen"
+	if !e.callbacks.NodeConnected(nodeID) {
+		reason = "StreamClosed"
+		err = fmt.Errorf("no envoy is connected to the ads server")
+	}
+
+	_ = e.recorder.SetCondition(e.namespace, name, v1alpha1.ClusterConditionEnvoyConnected, reason, err)
 }
 
 func (e *EnvoyADS) Sync() error {
+	return e.sync(fmt.Sprintf("v.%d", e.node.Generate()))
+}
+
+// sync regenerates the snapshots of every node with the given version
+func (e *EnvoyADS) sync(versionID string) error {
 	e.lock.Lock()
 	defer e.lock.Unlock()
 
@@ -187,8 +366,6 @@ func (e *EnvoyADS) Sync() error {
 		return err
 	}
 
-	versionID := fmt.Sprintf("v.%d", e.node.Generate())
-
 	if err := e.SyncObservability(versionID, clusters); err != nil {
 		return err
 	}
@@ -200,54 +377,89 @@ func (e *EnvoyADS) Sync() error {
 	return nil
 }
 
-func (e *EnvoyADS) SyncClusters(versionID string, clusters []*atlasCluster) error {
-	actualAMServices := []*k8scorev1.Service{}
+// SyncCluster regenerates the snapshots of the observability cluster and of the named downstream clusters only,
+// the snapshot of a cluster that was removed is cleared. Empty names only regenerate the observability snapshot.
+func (e *EnvoyADS) SyncCluster(names ...string) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
 
-	ca, err := e.secretsCache.Get(e.namespace, common.CASecretName)
+	clusters, err := e.getClusters()
 	if err != nil {
 		return err
 	}
 
-	server, err := e.secretsCache.Get(e.namespace, common.ServerSecretName)
-	if err != nil {
+	versionID := fmt.Sprintf("v.%d", e.node.Generate())
+
+	if err := e.SyncObservability(versionID, clusters); err != nil {
 		return err
 	}
 
-	client, err := e.secretsCache.Get(e.namespace, common.ClientSecretName)
+	byName := map[string]*atlasCluster{}
+	for _, cluster := range clusters {
+		byName[cluster.Name] = cluster
+	}
+
+	selected := []*atlasCluster{}
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+
+		if cluster, ok := byName[name]; ok {
+			selected = append(selected, cluster)
+			continue
+		}
+
+		e.log.WithField("id", name).Info("clearing snapshot of removed cluster")
+		e.clearSnapshot(common.ClusterNodeID(name))
+	}
+
+	if len(selected) == 0 {
+		return nil
+	}
+
+	return e.SyncClusters(versionID, selected)
+}
+
+func (e *EnvoyADS) SyncClusters(versionID string, clusters []*atlasCluster) error {
+	ca, err := e.secretsCache.Get(e.namespace, common.CASecretName)
 	if err != nil {
 		return err
 	}
 
-	amServices, err := e.services.List(e.namespace, v1.ListOptions{
-		LabelSelector: e.cli.String("alertmanager-selector"),
-	})
+	actualAMServices, err := e.alertManagerServices(e.namespace)
 	if err != nil {
 		return err
 	}
 
-	for _, service := range amServices.Items {
-		if _, ok := service.Spec.Selector["statefulset.kubernetes.io/pod-name"]; ok {
-			actualAMServices = append(actualAMServices, &service)
+	for _, cluster := range clusters {
+		// Note: every downstream cluster has its own certificate that is used both as server certificate
+		// for its listeners and as client certificate for connections to the observability cluster.
+		cert, err := e.secretsCache.Get(e.namespace, fmt.Sprintf(common.ClusterSecretNameFormat, cluster.Name))
+		if err != nil {
+			e.log.WithError(err).WithField("id", cluster.Name).Warn("cluster certificate not issued yet, skipping")
+			_ = e.recorder.SetCondition(cluster.Namespace, cluster.Name, v1alpha1.ClusterConditionSnapshotPublished, "CertificateMissing", err)
+			continue
 		}
-	}
 
-	for _, cluster := range clusters {
 		sidecarVirtualHost := buildVirtualHost("thanos_sidecar", []string{"*"}, "thanos_sidecar", "/", "", nil, false)
 		prometheusVirtualHost := buildVirtualHost("prometheus", []string{"*"}, "prometheus", "/", "", nil, false)
+		alertManagerVirtualHost := buildVirtualHost("alertmanager", []string{"*"}, "alertmanager", "/", "", nil, false)
 
-		// Note: these listeners are connected to from the Observerability Cluster Envoy Proxy
+		// Note: these listeners are connected to from the Observerability Cluster Envoy Proxy, only its
+		// client identity is accepted so other downstream clusters cannot reach them.
 		dsclusterListeners := []types.Resource{
-			buildListener("thanos_sidecar", common.ClusterInboundThanosPort, "thanos_sidecar", "server", true),
-			buildListener("prometheus", common.ClusterInboundPrometheusPort, "prometheus", "server", true),
-			// TODO: add alertmanager
+			buildListener("thanos_sidecar", common.ClusterInboundThanosPort, "thanos_sidecar", "server", true, common.ClientCommonName),
+			buildListener("prometheus", common.ClusterInboundPrometheusPort, "prometheus", "server", true, common.ClientCommonName),
+			buildListener("alertmanager", common.ClusterExposedAlertManagerPort, "alertmanager", "server", true, common.ClientCommonName),
 		}
 
 		// Note: these are cluster definitions for the downstream envoy proxy of services that define local services
 		// that are targets of connections
 		dsclusterClusters := []types.Resource{
-			buildCluster("thanos_sidecar", cluster.ThanosService, common.ObservabilityThanosPort, false, true),
-			buildCluster("prometheus", cluster.PrometheusService, common.PrometheusPort, false, false),
-			// TODO: add alertmanager
+			buildCluster("thanos_sidecar", cluster.ThanosService, cluster.ThanosServicePort, false, true),
+			buildCluster("prometheus", cluster.PrometheusService, cluster.PrometheusServicePort, false, false),
+			buildCluster("alertmanager", cluster.AlertManagerService, cluster.AlertManagerServicePort, false, false),
 		}
 
 		// Note: this is the virtualhost definitions for the listener for the sidecar to ensure
@@ -255,19 +467,24 @@ func (e *EnvoyADS) SyncClusters(versionID string, clusters []*atlasCluster) erro
 		dsclusterRoutes := []types.Resource{
 			buildRouteRaw("thanos_sidecar", []*route.VirtualHost{sidecarVirtualHost}),
 			buildRouteRaw("prometheus", []*route.VirtualHost{prometheusVirtualHost}),
-			// TODO: add alertmanager
+			buildRouteRaw("alertmanager", []*route.VirtualHost{alertManagerVirtualHost}),
 		}
 
 		// Note: we do not send the client cert, because the is controlled by the
 		// static cluster definition for the xds_cluster for dynamic discovery.
 		dsclusterSecretResources := []types.Resource{
-			buildSecretTLSValidation("validation", CombineCAs(ca)),
-			buildSecretTLSCertificate("server", server.Data["tls.crt"], server.Data["tls.key"]),
+			buildSecretTLSValidation("validation", CombineCAs(ca), e.revocationList()),
+			buildSecretTLSCertificate("server", cert.Data["tls.crt"], cert.Data["tls.key"]),
 		}
 
+		services := downstreamServiceResources(cluster.Name, cluster.Services)
+		dsclusterListeners = append(dsclusterListeners, services.listeners...)
+		dsclusterClusters = append(dsclusterClusters, services.clusters...)
+		dsclusterRoutes = append(dsclusterRoutes, services.routes...)
+
 		// If there are alertmanagers deployed, modify the the downstream cluster ADS configuration appropriately
 		if len(actualAMServices) > 0 && "localhost" != e.config.AtlasEnvoyAddress {
-			dsclusterClusters = append(dsclusterClusters, buildCluster("alertmanagers", e.config.AtlasEnvoyAddress, common.ObservabilityAlertManagerPort, true, true))
+			dsclusterClusters = append(dsclusterClusters, buildCluster("alertmanagers", e.config.AtlasEnvoyAddress, common.ObservabilityAlertManagerPort, true, true, common.ServerCommonName))
 
 			// Note: no secret is passed so it listens WITHOUT https since it's all local
 			dsclusterListeners = append(dsclusterListeners, buildListener("alertmanagers", common.ClusterInboundAlertManagerPort, "alertmanagers", "", false))
@@ -287,32 +504,51 @@ func (e *EnvoyADS) SyncClusters(versionID string, clusters []*atlasCluster) erro
 
 			dsclusterRoutes = append(dsclusterRoutes, buildRouteRaw("alertmanagers", amVirtualhosts))
 
-			dsclusterSecretResources = append(dsclusterSecretResources, buildSecretTLSCertificate("client", client.Data["tls.crt"], client.Data["tls.key"]))
+			dsclusterSecretResources = append(dsclusterSecretResources, buildSecretTLSCertificate("client", cert.Data["tls.crt"], cert.Data["tls.key"]))
 		}
 
-		dsclusterSnapshot := cache.NewSnapshot(
-			versionID,                // version of snapshot
-			[]types.Resource{},       // endpoints
-			dsclusterClusters,        // clusters
-			dsclusterRoutes,          // routes
-			dsclusterListeners,       // listeners
-			[]types.Resource{},       // runtimes
-			dsclusterSecretResources, // secrets
-		)
+		nodeID := common.ClusterNodeID(cluster.Name)
+		dsclusterSnapshot, hash, err := e.newSnapshot(nodeID, versionID, cache.SnapshotResources{
+			Endpoints: []types.Resource{},
+			Clusters:  dsclusterClusters,
+			Routes:    dsclusterRoutes,
+			Listeners: dsclusterListeners,
+			Runtimes:  []types.Resource{},
+			Secrets:   dsclusterSecretResources,
+		})
+		if err != nil {
+			return err
+		}
+
+		slog := e.log.WithField("id", nodeID).WithField("version", versionID)
+		if !e.snapshotChanged(nodeID, hash) {
+			slog.Debug("snapshot unchanged, skipping")
+			snapshotUpdates.WithLabelValues("unchanged").Inc()
+			continue
+		}
 
-		slog := e.log.WithField("id", cluster.Name).WithField("version", versionID)
 		slog.Info("generating snapshot ", versionID)
 		if err := dsclusterSnapshot.Consistent(); err != nil {
 			slog.WithError(err).Error("snapshot inconsistency")
+			_ = e.recorder.SetCondition(cluster.Namespace, cluster.Name, v1alpha1.ClusterConditionSnapshotPublished, "SnapshotInconsistent", err)
 			return err
 		}
 
-		if err := e.cache.SetSnapshot(cluster.Name, dsclusterSnapshot); err != nil {
+		if err := e.setSnapshot(nodeID, dsclusterSnapshot, hash); err != nil {
 			slog.WithError(err).Error("snapshot error")
+			_ = e.recorder.SetCondition(cluster.Namespace, cluster.Name, v1alpha1.ClusterConditionSnapshotPublished, "SnapshotFailed", err)
 			return err
 		}
 
-		snapshots.Inc()
+		snapshotUpdates.WithLabelValues("changed").Inc()
+
+		if err := e.recorder.Update(cluster.Namespace, cluster.Name, func(status *v1alpha1.AtlasClusterStatus) {
+			status.LastSnapshotVersion = versionID
+		}); err != nil {
+			slog.WithError(err).Warn("unable to update cluster status")
+		}
+
+		_ = e.recorder.SetCondition(cluster.Namespace, cluster.Name, v1alpha1.ClusterConditionSnapshotPublished, "Published", nil)
 	}
 
 	return nil
@@ -341,7 +577,7 @@ func (e *EnvoyADS) SyncObservability(versionID string, clusters []*atlasCluster)
 	}
 
 	secretResources := []types.Resource{
-		buildSecretTLSValidation("validation", CombineCAs(ca)),
+		buildSecretTLSValidation("validation", CombineCAs(ca), e.revocationList()),
 		buildSecretTLSCertificate("server", server.Data["tls.crt"], server.Data["tls.key"]),
 	}
 
@@ -350,22 +586,17 @@ func (e *EnvoyADS) SyncObservability(versionID string, clusters []*atlasCluster)
 	}
 
 	clusterResources := []types.Resource{}
+	endpointResources := []types.Resource{}
+	serviceListeners := []types.Resource{}
+	serviceRoutes := []types.Resource{}
+	healthCheckTargets := []healthCheckTarget{}
 	virtualhosts := []*route.VirtualHost{}
-	actualAMServices := []*k8scorev1.Service{}
 
-	amServices, err := e.services.List(common.MonitoringNamespace, v1.ListOptions{
-		LabelSelector: e.cli.String("alertmanager-selector"),
-	})
+	actualAMServices, err := e.alertManagerServices(common.MonitoringNamespace)
 	if err != nil {
 		return err
 	}
 
-	for _, service := range amServices.Items {
-		if _, ok := service.Spec.Selector["statefulset.kubernetes.io/pod-name"]; ok {
-			actualAMServices = append(actualAMServices, &service)
-		}
-	}
-
 	for i, service := range actualAMServices {
 		name := fmt.Sprintf("alertmanager%d", i)
 		fqdn := fmt.Sprintf("%s.%s.svc.cluster.local", service.GetName(), service.GetNamespace())
@@ -381,12 +612,38 @@ func (e *EnvoyADS) SyncObservability(versionID string, clusters []*atlasCluster)
 	promDomains := []string{"*"}
 	promVhRoutes := []*route.Route{}
 
+	// Note: identities of all downstream clusters, only these are accepted on listeners downstream envoys connect to
+	clusterSANs := []string{}
+
 	for _, r := range clusters {
 		thanosName := fmt.Sprintf("%s-thanos", r.Name)
 		promName := fmt.Sprintf("%s-prom", r.Name)
+		amName := fmt.Sprintf("%s-am", r.Name)
+		clusterSAN := fmt.Sprintf(common.ClusterCommonNameFormat, r.Name)
+		clusterSANs = append(clusterSANs, clusterSAN)
+
+		// Note: the downstream envoy must present the certificate issued to this cluster, every address of
+		// the cluster is an endpoint so a single unreachable node does not cut off the cluster
+		thanosCluster, thanosEndpoints := buildHostsCluster(thanosName, r.Addresses, r.ThanosPort, true, true, buildGRPCHealthCheck(r.HealthCheck, r.HealthCheck.thanosService), clusterSAN)
+		promCluster, promEndpoints := buildHostsCluster(promName, r.Addresses, r.PromPort, true, true, buildHTTPHealthCheck(r.HealthCheck, r.HealthCheck.prometheusPath), clusterSAN)
+		amCluster, amEndpoints := buildHostsCluster(amName, r.Addresses, r.AMPort, true, true, nil, clusterSAN)
+		clusterResources = append(clusterResources, thanosCluster, promCluster, amCluster)
+		if target, ok := e.delegateHealthChecks(thanosCluster, thanosEndpoints, r, upstreamThanos, r.ThanosPort); ok {
+			healthCheckTargets = append(healthCheckTargets, target)
+		}
+		if target, ok := e.delegateHealthChecks(promCluster, promEndpoints, r, upstreamPrometheus, r.PromPort); ok {
+			healthCheckTargets = append(healthCheckTargets, target)
+		}
 
-		clusterResources = append(clusterResources, buildCluster(thanosName, r.IP, r.ThanosPort, true, true))
-		clusterResources = append(clusterResources, buildCluster(promName, r.IP, r.PromPort, true, true))
+		if thanosEndpoints != nil {
+			endpointResources = append(endpointResources, thanosEndpoints)
+		}
+		if promEndpoints != nil {
+			endpointResources = append(endpointResources, promEndpoints)
+		}
+		if amEndpoints != nil {
+			endpointResources = append(endpointResources, amEndpoints)
+		}
 
 		domains := []string{
 			fmt.Sprintf("%s.%s.svc.cluster.local*", r.Name, r.Name),
@@ -403,6 +660,17 @@ func (e *EnvoyADS) SyncObservability(versionID string, clusters []*atlasCluster)
 		prefix := strings.Join(prefixParts, "/")
 
 		promVhRoutes = append(promVhRoutes, buildVirtualHostRoute(fmt.Sprintf("/%s/", prefix), promName, "", &[]string{"/"}[0], false))
+
+		// Note: the alertmanager of the cluster is served next to its prometheus on the same listener
+		amPrefix := strings.Join([]string{"alertmanager", r.Name}, "/")
+		promVhRoutes = append(promVhRoutes, buildVirtualHostRoute(fmt.Sprintf("/%s/", amPrefix), amName, "", &[]string{"/"}[0], false))
+
+		services := observabilityServiceResources(r, clusterSAN)
+		clusterResources = append(clusterResources, services.clusters...)
+		endpointResources = append(endpointResources, services.endpoints...)
+		serviceListeners = append(serviceListeners, services.listeners...)
+		serviceRoutes = append(serviceRoutes, services.routes...)
+		promVhRoutes = append(promVhRoutes, services.prefixRoutes...)
 	}
 
 	promVH := &route.VirtualHost{
@@ -412,11 +680,12 @@ func (e *EnvoyADS) SyncObservability(versionID string, clusters []*atlasCluster)
 	}
 
 	routeResources := []types.Resource{
-		buildRoute("xds_local", "xds_cluster", "localhost"),
 		buildRouteRaw("downstream_thanos", virtualhosts),
 		buildRouteRaw("downstream_prometheus", []*route.VirtualHost{promVH}),
 	}
 
+	routeResources = append(routeResources, serviceRoutes...)
+
 	if len(actualAMServices) > 0 {
 		amVirtualhosts := []*route.VirtualHost{}
 
@@ -442,13 +711,14 @@ func (e *EnvoyADS) SyncObservability(versionID string, clusters []*atlasCluster)
 	}
 
 	listenerResources := []types.Resource{
-		buildListener("xds_external", common.ObservabilityADSPort, "xds_local", "server", false),                       // 10900
 		buildListener("downstream_thanos", common.ObservabilityThanosPort, "downstream_thanos", "", false),             // 10901
 		buildListener("downstream_prometheus", common.ObservabilityPrometheusPort, "downstream_prometheus", "", false), // 10904
 	}
 
+	listenerResources = append(listenerResources, serviceListeners...)
+
 	if len(actualAMServices) > 0 {
-		listenerResources = append(listenerResources, buildListener("upstream_alertmanagers", common.ObservabilityAlertManagerPort, "upstream_alertmanagers", "server", true)) // 10903
+		listenerResources = append(listenerResources, buildListener("upstream_alertmanagers", common.ObservabilityAlertManagerPort, "upstream_alertmanagers", "server", true, clusterSANs...)) // 10903
 	}
 
 	if e.debugEnvoy {
@@ -456,47 +726,61 @@ func (e *EnvoyADS) SyncObservability(versionID string, clusters []*atlasCluster)
 		listenerResources = append(listenerResources, buildListener("google", 10001, "google_route", "server", false))
 	}
 
-	snapshot := cache.NewSnapshot(
-		versionID,
-		[]types.Resource{}, // endpoints
-		clusterResources,   // clusters
-		routeResources,     // routes
-		listenerResources,  // listeners
-		[]types.Resource{}, // runtimes
-		secretResources,    // secrets
-	)
+	snapshot, hash, err := e.newSnapshot(common.EnvoyADSObservabilityID, versionID, cache.SnapshotResources{
+		Endpoints: endpointResources,
+		Clusters:  clusterResources,
+		Routes:    routeResources,
+		Listeners: listenerResources,
+		Runtimes:  []types.Resource{},
+		Secrets:   secretResources,
+	})
+	if err != nil {
+		return err
+	}
+
+	e.hds.update(healthCheckTargets)
 
 	slog := e.log.WithField("id", common.EnvoyADSObservabilityID).WithField("version", versionID)
+	if !e.snapshotChanged(common.EnvoyADSObservabilityID, hash) {
+		slog.Debug("snapshot unchanged, skipping")
+		snapshotUpdates.WithLabelValues("unchanged").Inc()
+		return nil
+	}
+
 	slog.Info("generating snapshot ", versionID)
 	if err := snapshot.Consistent(); err != nil {
 		slog.WithError(err).Errorf("snapshot inconsistency")
 		return err
 	}
 
-	if err := e.cache.SetSnapshot(common.EnvoyADSObservabilityID, snapshot); err != nil {
+	if err := e.setSnapshot(common.EnvoyADSObservabilityID, snapshot, hash); err != nil {
 		slog.WithError(err).Error("unable to set snapshot")
 		return err
 	}
 
-	snapshots.Inc()
+	snapshotUpdates.WithLabelValues("changed").Inc()
 
 	return nil
 }
 
-// RunManagementServer starts an xDS server at the given port.
-func (e *EnvoyADS) RunServer(ctx context.Context, log *logrus.Entry, server server.Server, port int) {
+// RunManagementServer starts an xDS server at the given port, when creds are given the server terminates TLS.
+func (e *EnvoyADS) RunServer(ctx context.Context, log *logrus.Entry, server server.Server, address string, port int, creds credentials.TransportCredentials) {
 	var grpcOptions []grpc.ServerOption
 	grpcOptions = append(grpcOptions, grpc.MaxConcurrentStreams(e.grpcMaxConcurrentStreams))
+	if creds != nil {
+		grpcOptions = append(grpcOptions, grpc.Creds(creds))
+	}
 	grpcServer := grpc.NewServer(grpcOptions...)
 
-	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	lis, err := net.Listen("tcp", net.JoinHostPort(address, strconv.Itoa(port)))
 	if err != nil {
 		log.WithError(err).Fatal("Unable to start grpc network listener")
 	}
 
 	registerServer(grpcServer, server)
+	healthservice.RegisterHealthDiscoveryServiceServer(grpcServer, e.hds)
 
-	log.WithFields(logrus.Fields{"port": port}).Info("Starting Envoy ADS GRPC Management Server")
+	log.WithFields(logrus.Fields{"address": address, "port": port, "tls": creds != nil}).Info("Starting Envoy ADS GRPC Management Server")
 
 	go func() {
 		if err = grpcServer.Serve(lis); err != nil {
@@ -522,58 +806,127 @@ func registerServer(grpcServer *grpc.Server, server server.Server) {
 	runtimeservice.RegisterRuntimeDiscoveryServiceServer(grpcServer, server)
 }
 
-func (e *EnvoyADS) getClusters() ([]*atlasCluster, error) {
-	requirement, err := labels.NewRequirement(common.AtlasClusterLabel, selection.Exists, []string{})
+// alertManagerServices returns the services of the individual alertmanager replicas in the namespace ordered
+// by name, the index of a replica in the routes depends on the order
+func (e *EnvoyADS) alertManagerServices(namespace string) ([]*k8scorev1.Service, error) {
+	selector, err := labels.Parse(e.cli.String("alertmanager-selector"))
 	if err != nil {
 		return nil, err
 	}
-	selector := labels.NewSelector().Add(*requirement)
 
-	services, err := e.servicesCache.List(e.namespace, selector)
+	services, err := e.servicesCache.List(namespace, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].Name < services[j].Name
+	})
+
+	actualAMServices := []*k8scorev1.Service{}
+	for _, service := range services {
+		if _, ok := service.Spec.Selector["statefulset.kubernetes.io/pod-name"]; ok {
+			actualAMServices = append(actualAMServices, service)
+		}
+	}
+
+	return actualAMServices, nil
+}
+
+func (e *EnvoyADS) getClusters() ([]*atlasCluster, error) {
+	atlasClusters, err := e.atlasClustersCache.List(e.namespace, labels.Everything())
 	if err != nil {
 		return nil, err
 	}
 
 	clusters := []*atlasCluster{}
 
-	for _, s := range services {
-		annotations := s.GetAnnotations()
-		labels := s.GetLabels()
+	for _, c := range atlasClusters {
+		if common.IsReservedClusterName(c.Name) {
+			e.log.WithField("cluster", c.Name).Warn("cluster name is reserved, skipping")
+			continue
+		}
+
+		addresses := externalAddresses(c)
+		if len(addresses) == 0 {
+			e.log.WithField("cluster", c.Name).Warn("cluster has no external addresses, skipping")
+			continue
+		}
 
 		replicas := 1
-		if v, ok := labels[common.ReplicasLabel]; ok {
-			i, err := strconv.Atoi(v)
-			if err != nil {
-				logrus.WithError(err).Error("unable to convert string to int")
-			} else {
-				replicas = i
-			}
+		if c.Spec.Replicas > 0 {
+			replicas = c.Spec.Replicas
 		}
 
-		thanosService := common.ThanosFQDN
-		if v, ok := annotations[common.ThanosServiceAnnotation]; ok {
-			thanosService = v
+		thanosService, thanosServicePort := serviceEndpoint(c.Spec.Thanos, common.ThanosFQDN, common.ThanosPort)
+		prometheusService, prometheusServicePort := serviceEndpoint(c.Spec.Prometheus, common.PrometheusFQDN, common.PrometheusPort)
+		alertManagerService, alertManagerServicePort := serviceEndpoint(c.Spec.AlertManager, common.AlertManagerFQDN, common.AlertManagerPort)
+
+		healthCheck, err := newHealthCheckConfig(c.Spec.HealthCheck)
+		if err != nil {
+			e.log.WithError(err).WithField("cluster", c.Name).Warn("invalid health check settings, using the defaults")
+			healthCheck, _ = newHealthCheckConfig(nil)
 		}
 
-		prometheusService := common.PrometheusFQDN
-		if v, ok := annotations[common.PrometheusServiceAnnotation]; ok {
-			prometheusService = v
+		services, errs := newExposedServices(c.Spec.Services)
+		for _, err := range errs {
+			e.log.WithError(err).WithField("cluster", c.Name).Warn("invalid exposed service, skipping")
 		}
 
 		clusters = append(clusters, &atlasCluster{
-			Name:       s.Name,
-			Namespace:  s.Namespace,
+			Name:       c.Name,
+			Namespace:  c.Namespace,
 			Replicas:   replicas,
-			IP:         s.Spec.ExternalIPs[0],
+			Addresses:  addresses,
 			ThanosPort: uint32(common.ClusterInboundThanosPort),
 			PromPort:   uint32(common.ClusterInboundPrometheusPort),
-			AMPort:     uint32(common.ClusterInboundAlertManagerPort),
-			service:    s,
-
-			ThanosService:     thanosService,
-			PrometheusService: prometheusService,
+			AMPort:     uint32(common.ClusterExposedAlertManagerPort),
+			cluster:    c,
+
+			ThanosService:           thanosService,
+			ThanosServicePort:       thanosServicePort,
+			PrometheusService:       prometheusService,
+			PrometheusServicePort:   prometheusServicePort,
+			AlertManagerService:     alertManagerService,
+			AlertManagerServicePort: alertManagerServicePort,
+			HealthCheck:             healthCheck,
+			Services:                services,
 		})
 	}
 
+	// Note: the cache lists in no particular order, the order of the virtual hosts must not change between syncs
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Name < clusters[j].Name
+	})
+
+	e.claimListenPorts(clusters)
+
 	return clusters, nil
 }
+
+// serviceEndpoint returns the service fqdn and port of a component, falling back to the defaults for unset fields
+func serviceEndpoint(endpoint *v1alpha1.ServiceEndpoint, defaultService string, defaultPort uint32) (string, uint32) {
+	service, port := defaultService, defaultPort
+	if endpoint != nil && endpoint.Service != "" {
+		service = endpoint.Service
+	}
+	if endpoint != nil && endpoint.Port > 0 {
+		port = endpoint.Port
+	}
+	return service, port
+}
+
+// externalAddresses returns the external addresses of the cluster without blanks and duplicates
+func externalAddresses(cluster *v1alpha1.AtlasCluster) []string {
+	seen := map[string]bool{}
+	addresses := []string{}
+	for _, address := range cluster.Spec.ExternalAddresses {
+		address = strings.TrimSpace(address)
+		if address == "" || seen[address] {
+			continue
+		}
+		seen[address] = true
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
@@ -0,0 +1,328 @@
+package envoy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"flag"
+	"math/big"
+	"testing"
+	"time"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	tls "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	healthservice "github.com/envoyproxy/go-control-plane/envoy/service/health/v3"
+	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+
+	"github.com/goatlas-io/atlas/pkg/common"
+	"github.com/goatlas-io/atlas/pkg/config"
+)
+
+// newTestCLIContext returns a cli context with the flags the ads server reads
+func newTestCLIContext() *cli.Context {
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	set.String("namespace", common.MonitoringNamespace, "")
+	set.String("alertmanager-selector", common.ObservabilityAlertManagerServiceLabel, "")
+	return cli.NewContext(nil, set, nil)
+}
+
+// syncTestManifests generates the snapshots of the manifests in testdata
+func syncTestManifests(t *testing.T) *EnvoyADS {
+	t.Helper()
+
+	ads := newTestADS(t)
+	if err := ads.sync("v.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	return ads
+}
+
+// newTestADS returns an ads server for the manifests in testdata without generating any snapshots
+func newTestADS(t *testing.T) *EnvoyADS {
+	t.Helper()
+
+	objects, err := ReadManifests("testdata/manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := logrus.New()
+	log.SetLevel(logrus.ErrorLevel)
+
+	conf := config.NewEnvoyADSConfig()
+	conf.AtlasEnvoyAddress = "envoy.atlas.local"
+
+	ads, err := newOfflineADS(conf, logrus.NewEntry(log), newTestCLIContext(), objects)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ads
+}
+
+func getSnapshot(t *testing.T, ads *EnvoyADS, nodeID string) cache.Snapshot {
+	t.Helper()
+
+	snapshot, err := ads.cache.GetSnapshot(nodeID)
+	if err != nil {
+		t.Fatalf("no snapshot for node %s: %s", nodeID, err)
+	}
+	return snapshot
+}
+
+// clusterSANMatchers returns the subject alternative names the cluster accepts from its upstream
+func clusterSANMatchers(t *testing.T, snapshot cache.Snapshot, name string) []*matcher.StringMatcher {
+	t.Helper()
+
+	r, ok := snapshot.GetResources(resource.ClusterType)[name]
+	if !ok {
+		t.Fatalf("cluster %s not found", name)
+	}
+
+	upstream := &tls.UpstreamTlsContext{}
+	if err := ptypes.UnmarshalAny(r.(*cluster.Cluster).GetTransportSocket().GetTypedConfig(), upstream); err != nil {
+		t.Fatalf("cluster %s has no upstream tls context: %s", name, err)
+	}
+	return upstream.GetCommonTlsContext().GetCombinedValidationContext().GetDefaultValidationContext().GetMatchSubjectAltNames()
+}
+
+// listenerSANMatchers returns the subject alternative names the listener accepts from its clients
+func listenerSANMatchers(t *testing.T, snapshot cache.Snapshot, name string) []*matcher.StringMatcher {
+	t.Helper()
+
+	r, ok := snapshot.GetResources(resource.ListenerType)[name]
+	if !ok {
+		t.Fatalf("listener %s not found", name)
+	}
+
+	var socket *core.TransportSocket
+	for _, chain := range r.(*listener.Listener).GetFilterChains() {
+		socket = chain.GetTransportSocket()
+	}
+
+	downstream := &tls.DownstreamTlsContext{}
+	if err := ptypes.UnmarshalAny(socket.GetTypedConfig(), downstream); err != nil {
+		t.Fatalf("listener %s has no downstream tls context: %s", name, err)
+	}
+	if !downstream.GetRequireClientCertificate().GetValue() {
+		t.Fatalf("listener %s does not require a client certificate", name)
+	}
+	return downstream.GetCommonTlsContext().GetCombinedValidationContext().GetDefaultValidationContext().GetMatchSubjectAltNames()
+}
+
+// newTestCertificate returns a certificate for the common name the way Atlas issues them
+func newTestCertificate(t *testing.T, commonName string) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// acceptsCertificate matches the DNS subject alternative names of the certificate the way envoy does
+// for exact matchers
+func acceptsCertificate(matchers []*matcher.StringMatcher, cert *x509.Certificate) bool {
+	for _, m := range matchers {
+		for _, name := range cert.DNSNames {
+			if m.GetExact() == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func expectSANs(t *testing.T, what string, matchers []*matcher.StringMatcher, sans ...string) {
+	t.Helper()
+
+	got := []string{}
+	for _, m := range matchers {
+		if m.GetExact() == "" {
+			t.Fatalf("%s has a non exact matcher %v", what, m)
+		}
+		got = append(got, m.GetExact())
+	}
+
+	if len(got) != len(sans) {
+		t.Fatalf("%s accepts %v, expected %v", what, got, sans)
+	}
+	for i := range got {
+		if got[i] != sans[i] {
+			t.Fatalf("%s accepts %v, expected %v", what, got, sans)
+		}
+	}
+}
+
+func TestSyncObservabilityAcceptsOnlyTheClusterCertificate(t *testing.T) {
+	ads := syncTestManifests(t)
+	snapshot := getSnapshot(t, ads, common.EnvoyADSObservabilityID)
+
+	east := newTestCertificate(t, "east.cluster.atlas")
+	west := newTestCertificate(t, "west.cluster.atlas")
+	client := newTestCertificate(t, common.ClientCommonName)
+
+	for _, name := range []string{"east-thanos", "east-prom", "east-am"} {
+		matchers := clusterSANMatchers(t, snapshot, name)
+		expectSANs(t, name, matchers, "east.cluster.atlas")
+
+		if !acceptsCertificate(matchers, east) {
+			t.Errorf("%s rejects the certificate of its cluster", name)
+		}
+		if acceptsCertificate(matchers, west) {
+			t.Errorf("%s accepts the certificate of another cluster", name)
+		}
+		if acceptsCertificate(matchers, client) {
+			t.Errorf("%s accepts the observability client certificate", name)
+		}
+	}
+
+	matchers := listenerSANMatchers(t, snapshot, "upstream_alertmanagers")
+	expectSANs(t, "upstream_alertmanagers", matchers, "east.cluster.atlas", "west.cluster.atlas")
+
+	if acceptsCertificate(matchers, client) {
+		t.Error("upstream_alertmanagers accepts the observability client certificate")
+	}
+	if acceptsCertificate(matchers, newTestCertificate(t, "north.cluster.atlas")) {
+		t.Error("upstream_alertmanagers accepts the certificate of an unknown cluster")
+	}
+
+	// Note: downstream envoys connect to envoy-ads with their own certificate, proxying them through the
+	// observability envoy would hand them its client identity
+	if _, ok := snapshot.GetResources(resource.ListenerType)["xds_external"]; ok {
+		t.Error("the observability envoy proxies downstream envoys to envoy-ads")
+	}
+}
+
+func TestSyncClustersAcceptsOnlyTheObservabilityClient(t *testing.T) {
+	ads := syncTestManifests(t)
+	snapshot := getSnapshot(t, ads, common.ClusterNodeID("east"))
+
+	client := newTestCertificate(t, common.ClientCommonName)
+	west := newTestCertificate(t, "west.cluster.atlas")
+
+	for _, name := range []string{"thanos_sidecar", "prometheus", "alertmanager"} {
+		matchers := listenerSANMatchers(t, snapshot, name)
+		expectSANs(t, name, matchers, common.ClientCommonName)
+
+		if !acceptsCertificate(matchers, client) {
+			t.Errorf("%s rejects the observability client certificate", name)
+		}
+		if acceptsCertificate(matchers, west) {
+			t.Errorf("%s accepts the certificate of another cluster", name)
+		}
+	}
+
+	expectSANs(t, "alertmanagers", clusterSANMatchers(t, snapshot, "alertmanagers"), common.ServerCommonName)
+}
+
+func TestSyncOnlyCountsSnapshotsThatAreSet(t *testing.T) {
+	ads := syncTestManifests(t)
+
+	set := testutil.ToFloat64(snapshots)
+	unchanged := testutil.ToFloat64(snapshotUpdates.WithLabelValues("unchanged"))
+
+	if err := ads.sync("v.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := testutil.ToFloat64(snapshots) - set; got != 0 {
+		t.Errorf("expected no snapshot to be set for unchanged resources, got %f", got)
+	}
+	if got := testutil.ToFloat64(snapshotUpdates.WithLabelValues("unchanged")) - unchanged; got != float64(len(ads.snapshotHashes)) {
+		t.Errorf("expected %d unchanged snapshots, got %f", len(ads.snapshotHashes), got)
+	}
+}
+
+// endpointReport is a health report of the observability envoy for a single endpoint
+func endpointReport(envoyCluster, address string, status core.HealthStatus) *healthservice.EndpointHealthResponse {
+	return &healthservice.EndpointHealthResponse{
+		ClusterEndpointsHealth: []*healthservice.ClusterEndpointsHealth{{
+			ClusterName: envoyCluster,
+			LocalityEndpointsHealth: []*healthservice.LocalityEndpointsHealth{{
+				EndpointsHealth: []*healthservice.EndpointHealth{{
+					Endpoint: &endpoint.Endpoint{Address: &core.Address{Address: &core.Address_SocketAddress{
+						SocketAddress: &core.SocketAddress{Address: address},
+					}}},
+					HealthStatus: status,
+				}},
+			}},
+		}},
+	}
+}
+
+func TestSyncObservabilityDelegatesHealthChecksOfStaticAddresses(t *testing.T) {
+	ads := newTestADS(t)
+	ads.hds = newHealthDiscoveryServer(ads.log, false)
+
+	if err := ads.sync("v.0"); err != nil {
+		t.Fatal(err)
+	}
+	snapshot := getSnapshot(t, ads, common.EnvoyADSObservabilityID)
+
+	// Note: east only has IPs and is discovered through EDS, west has a DNS name the ads server does not resolve
+	for name, delegated := range map[string]bool{"east-thanos": true, "east-prom": true, "west-thanos": false, "west-prom": false} {
+		c := snapshot.GetResources(resource.ClusterType)[name].(*cluster.Cluster)
+		if delegated && len(c.GetHealthChecks()) != 0 {
+			t.Errorf("%s is health checked by HDS and by the cluster", name)
+		}
+		if !delegated && len(c.GetHealthChecks()) == 0 {
+			t.Errorf("%s is not health checked", name)
+		}
+		if _, ok := ads.hds.targets[name]; ok != delegated {
+			t.Errorf("expected %s to be assigned to HDS: %t", name, delegated)
+		}
+	}
+
+	ads.hds.record(endpointReport("east-thanos", "10.0.0.1", core.HealthStatus_UNHEALTHY))
+	ads.hds.record(endpointReport("east-prom", "10.0.0.1", core.HealthStatus_HEALTHY))
+
+	if err := ads.sync("v.1"); err != nil {
+		t.Fatal(err)
+	}
+	snapshot = getSnapshot(t, ads, common.EnvoyADSObservabilityID)
+
+	for name, expected := range map[string]core.HealthStatus{"east-thanos": core.HealthStatus_UNHEALTHY, "east-prom": core.HealthStatus_HEALTHY} {
+		assignment := snapshot.GetResources(resource.EndpointType)[name].(*endpoint.ClusterLoadAssignment)
+		for _, lbEndpoint := range assignment.GetEndpoints()[0].GetLbEndpoints() {
+			status := core.HealthStatus_UNKNOWN
+			if lbEndpoint.GetEndpoint().GetAddress().GetSocketAddress().GetAddress() == "10.0.0.1" {
+				status = expected
+			}
+			if lbEndpoint.GetHealthStatus() != status {
+				t.Errorf("expected %s endpoint %v to be %s, got %s", name, lbEndpoint.GetEndpoint().GetAddress(), status, lbEndpoint.GetHealthStatus())
+			}
+		}
+	}
+}
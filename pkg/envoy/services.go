@@ -0,0 +1,262 @@
+package envoy
+
+import (
+	"fmt"
+	"strings"
+
+	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/goatlas-io/atlas/pkg/apis/atlas.goatlas.io/v1alpha1"
+	"github.com/goatlas-io/atlas/pkg/common"
+)
+
+// reservedPorts are the ports the observability and downstream envoys already listen on
+var reservedPorts = map[uint32]bool{
+	9000:                                  true, // admin
+	common.ObservabilityThanosPort:        true,
+	common.ObservabilityAlertManagerPort:  true,
+	common.ObservabilityPrometheusPort:    true,
+	common.ClusterInboundThanosPort:       true,
+	common.ClusterInboundAlertManagerPort: true,
+	common.ClusterInboundPrometheusPort:   true,
+	common.ClusterExposedAlertManagerPort: true,
+	common.ClusterInboundServicesPort:     true,
+	common.ClusterInboundTCPServicesPort:  true,
+}
+
+// reservedPathPrefixes are routed to the prometheus and alertmanager of the clusters
+var reservedPathPrefixes = []string{"/prom", "/alertmanager"}
+
+// exposedService is a validated service of a downstream cluster that is exposed to the observability cluster
+type exposedService struct {
+	Name       string
+	Service    string
+	Port       uint32
+	Protocol   string
+	PathPrefix string
+	// ListenPort is only set for grpc and tcp services, the observability envoy listens on it
+	ListenPort uint32
+}
+
+// newExposedServices validates the exposed services of a cluster and applies the defaults, invalid services
+// are left out and returned as errors
+func newExposedServices(specs []v1alpha1.ExposedService) ([]exposedService, []error) {
+	services := []exposedService{}
+	errs := []error{}
+
+	names := map[string]bool{}
+	hosts := map[string]bool{}
+	prefixes := map[string]bool{}
+	ports := map[uint32]bool{}
+
+	for _, spec := range specs {
+		s := exposedService{
+			Name:     spec.Name,
+			Service:  strings.TrimSpace(spec.Service),
+			Port:     spec.Port,
+			Protocol: strings.ToLower(spec.Protocol),
+		}
+		if s.Protocol == "" {
+			s.Protocol = v1alpha1.ServiceProtocolHTTP
+		}
+
+		if msgs := validation.IsDNS1123Label(s.Name); len(msgs) > 0 {
+			errs = append(errs, fmt.Errorf("invalid service name %q: %s", s.Name, strings.Join(msgs, ", ")))
+			continue
+		}
+		if names[s.Name] {
+			errs = append(errs, fmt.Errorf("duplicate service name %q", s.Name))
+			continue
+		}
+		if s.Service == "" {
+			errs = append(errs, fmt.Errorf("service %q has no service fqdn", s.Name))
+			continue
+		}
+		if s.Port == 0 || s.Port > 65535 {
+			errs = append(errs, fmt.Errorf("service %q has invalid port %d", s.Name, s.Port))
+			continue
+		}
+
+		switch s.Protocol {
+		case v1alpha1.ServiceProtocolHTTP:
+			s.PathPrefix = spec.PathPrefix
+			if s.PathPrefix == "" {
+				s.PathPrefix = s.Name
+			}
+			s.PathPrefix = "/" + strings.Trim(s.PathPrefix, "/")
+
+			if s.PathPrefix == "/" || isReservedPathPrefix(s.PathPrefix) {
+				errs = append(errs, fmt.Errorf("service %q has reserved path prefix %q", s.Name, s.PathPrefix))
+				continue
+			}
+			if prefixes[s.PathPrefix] {
+				errs = append(errs, fmt.Errorf("service %q has duplicate path prefix %q", s.Name, s.PathPrefix))
+				continue
+			}
+		case v1alpha1.ServiceProtocolGRPC, v1alpha1.ServiceProtocolTCP:
+			s.ListenPort = spec.ListenPort
+			if s.ListenPort == 0 || s.ListenPort > 65535 {
+				errs = append(errs, fmt.Errorf("%s service %q has invalid listen port %d", s.Protocol, s.Name, s.ListenPort))
+				continue
+			}
+			if reservedPorts[s.ListenPort] || ports[s.ListenPort] {
+				errs = append(errs, fmt.Errorf("service %q listen port %d is already in use", s.Name, s.ListenPort))
+				continue
+			}
+		default:
+			errs = append(errs, fmt.Errorf("service %q has unknown protocol %q", s.Name, spec.Protocol))
+			continue
+		}
+
+		// Note: http and grpc services share one listener on the downstream envoy and are routed by host
+		if s.Protocol != v1alpha1.ServiceProtocolTCP && hosts[s.host()] {
+			errs = append(errs, fmt.Errorf("service %q duplicates %s", s.Name, s.host()))
+			continue
+		}
+
+		names[s.Name] = true
+		if s.Protocol != v1alpha1.ServiceProtocolTCP {
+			hosts[s.host()] = true
+		}
+		if s.PathPrefix != "" {
+			prefixes[s.PathPrefix] = true
+		}
+		if s.ListenPort > 0 {
+			ports[s.ListenPort] = true
+		}
+
+		services = append(services, s)
+	}
+
+	return services, errs
+}
+
+func isReservedPathPrefix(prefix string) bool {
+	for _, reserved := range reservedPathPrefixes {
+		if prefix == reserved || strings.HasPrefix(prefix, reserved+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// host is the host the downstream envoy routes http and grpc services by
+func (s exposedService) host() string {
+	return fmt.Sprintf("%s:%d", s.Service, s.Port)
+}
+
+// downstreamName is the name of the resources of the service in the snapshot of the downstream envoy
+func (s exposedService) downstreamName() string {
+	return fmt.Sprintf("service_%s", s.Name)
+}
+
+// serverName is the SNI the downstream envoy routes tcp services of the cluster by
+func (s exposedService) serverName(clusterName string) string {
+	return fmt.Sprintf("%s.%s.%s", s.Name, clusterName, common.DNSTLD)
+}
+
+// claimListenPorts leaves out the services whose listen port is already used by a service of another cluster,
+// clusters are ordered by name so the first cluster keeps the port
+func (e *EnvoyADS) claimListenPorts(clusters []*atlasCluster) {
+	claimed := map[uint32]string{}
+
+	for _, c := range clusters {
+		services := []exposedService{}
+		for _, s := range c.Services {
+			if s.ListenPort > 0 {
+				if owner, ok := claimed[s.ListenPort]; ok {
+					e.log.WithField("cluster", c.Name).WithField("service", s.Name).WithField("owner", owner).Warnf("listen port %d is already in use, skipping service", s.ListenPort)
+					continue
+				}
+				claimed[s.ListenPort] = fmt.Sprintf("%s/%s", c.Name, s.Name)
+			}
+			services = append(services, s)
+		}
+		c.Services = services
+	}
+}
+
+// serviceResources are the resources of the exposed services of a cluster
+type serviceResources struct {
+	listeners []types.Resource
+	clusters  []types.Resource
+	endpoints []types.Resource
+	routes    []types.Resource
+
+	// prefixRoutes route the path prefixes of http services on the downstream_prometheus listener
+	prefixRoutes []*route.Route
+}
+
+// downstreamServiceResources builds the resources of the downstream envoy of a cluster, http and grpc services
+// are routed by host and tcp services by SNI on two shared listeners. Only the client identity of the
+// observability envoy is accepted.
+func downstreamServiceResources(clusterName string, services []exposedService) *serviceResources {
+	resources := &serviceResources{}
+	virtualhosts := []*route.VirtualHost{}
+	tcpRoutes := []tcpRoute{}
+
+	for _, s := range services {
+		name := s.downstreamName()
+		resources.clusters = append(resources.clusters, buildCluster(name, s.Service, s.Port, false, s.Protocol == v1alpha1.ServiceProtocolGRPC))
+
+		if s.Protocol == v1alpha1.ServiceProtocolTCP {
+			tcpRoutes = append(tcpRoutes, tcpRoute{serverName: s.serverName(clusterName), cluster: name})
+			continue
+		}
+
+		virtualhosts = append(virtualhosts, buildVirtualHost(name, []string{s.host()}, name, "/", "", nil, false))
+	}
+
+	if len(virtualhosts) > 0 {
+		resources.listeners = append(resources.listeners, buildListener("services", common.ClusterInboundServicesPort, "services", "server", true, common.ClientCommonName))
+		resources.routes = append(resources.routes, buildRouteRaw("services", virtualhosts))
+	}
+
+	if len(tcpRoutes) > 0 {
+		resources.listeners = append(resources.listeners, buildSNIListener("tcp_services", common.ClusterInboundTCPServicesPort, tcpRoutes, "server", true, common.ClientCommonName))
+	}
+
+	return resources
+}
+
+// observabilityServiceResources builds the resources of the observability envoy for the exposed services of a
+// cluster, the downstream envoy must present the certificate issued to the cluster
+func observabilityServiceResources(r *atlasCluster, clusterSAN string) *serviceResources {
+	resources := &serviceResources{}
+
+	for _, s := range r.Services {
+		name := fmt.Sprintf("%s-service-%s", r.Name, s.Name)
+
+		var endpoints *endpoint.ClusterLoadAssignment
+
+		switch s.Protocol {
+		case v1alpha1.ServiceProtocolTCP:
+			c, eps := buildTCPCluster(name, r.Addresses, common.ClusterInboundTCPServicesPort, s.serverName(r.Name), clusterSAN)
+			resources.clusters = append(resources.clusters, c)
+			resources.listeners = append(resources.listeners, buildTCPListener(name, s.ListenPort, name, "", false))
+			endpoints = eps
+		case v1alpha1.ServiceProtocolGRPC:
+			c, eps := buildHostsCluster(name, r.Addresses, common.ClusterInboundServicesPort, true, true, nil, clusterSAN)
+			resources.clusters = append(resources.clusters, c)
+			resources.listeners = append(resources.listeners, buildListener(name, s.ListenPort, name, "", false))
+			resources.routes = append(resources.routes, buildRouteRaw(name, []*route.VirtualHost{
+				buildVirtualHost(name, []string{"*"}, name, "/", s.host(), nil, false),
+			}))
+			endpoints = eps
+		default:
+			c, eps := buildHostsCluster(name, r.Addresses, common.ClusterInboundServicesPort, true, true, nil, clusterSAN)
+			resources.clusters = append(resources.clusters, c)
+			resources.prefixRoutes = append(resources.prefixRoutes, buildVirtualHostRoute(fmt.Sprintf("%s/%s/", s.PathPrefix, r.Name), name, s.host(), &[]string{"/"}[0], false))
+			endpoints = eps
+		}
+
+		if endpoints != nil {
+			resources.endpoints = append(resources.endpoints, endpoints)
+		}
+	}
+
+	return resources
+}
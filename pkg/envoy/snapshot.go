@@ -0,0 +1,95 @@
+package envoy
+
+import (
+	"hash/fnv"
+	"sort"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cache "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/golang/protobuf/proto"
+	protov2 "google.golang.org/protobuf/proto"
+)
+
+// snapshotHash holds a hash of the marshalled resources of every resource type of a snapshot
+type snapshotHash [types.UnknownType]uint64
+
+// newSnapshot builds the snapshot for a node along with the hash of its resources, resource types whose
+// resources are identical to the current snapshot of the node keep their version so envoy is not sent a
+// response for them
+func (e *EnvoyADS) newSnapshot(nodeID, versionID string, resources cache.SnapshotResources) (cache.Snapshot, snapshotHash, error) {
+	snapshot := cache.NewSnapshotWithResources(versionID, resources)
+
+	hash := snapshotHash{}
+	for typ := range snapshot.Resources {
+		h, err := hashResources(snapshot.Resources[typ].Items)
+		if err != nil {
+			return snapshot, hash, err
+		}
+		hash[typ] = h
+	}
+
+	previousHash, ok := e.snapshotHashes[nodeID]
+	if !ok {
+		return snapshot, hash, nil
+	}
+
+	previous, err := e.cache.GetSnapshot(nodeID)
+	if err != nil {
+		return snapshot, hash, nil
+	}
+
+	for typ := range snapshot.Resources {
+		if previousHash[typ] == hash[typ] {
+			snapshot.Resources[typ] = previous.Resources[typ]
+		}
+	}
+
+	return snapshot, hash, nil
+}
+
+// snapshotChanged returns true if the resources differ from the snapshot that was last set for the node
+func (e *EnvoyADS) snapshotChanged(nodeID string, hash snapshotHash) bool {
+	previous, ok := e.snapshotHashes[nodeID]
+	return !ok || previous != hash
+}
+
+// setSnapshot publishes the snapshot for the node and remembers the hash of its resources
+func (e *EnvoyADS) setSnapshot(nodeID string, snapshot cache.Snapshot, hash snapshotHash) error {
+	if err := e.cache.SetSnapshot(nodeID, snapshot); err != nil {
+		return err
+	}
+
+	e.snapshotHashes[nodeID] = hash
+	snapshots.Inc()
+
+	return nil
+}
+
+// clearSnapshot removes the snapshot of a node that no longer exists
+func (e *EnvoyADS) clearSnapshot(nodeID string) {
+	e.cache.ClearSnapshot(nodeID)
+	delete(e.snapshotHashes, nodeID)
+}
+
+// hashResources hashes the deterministically marshalled resources in order of their names
+func hashResources(items map[string]types.ResourceWithTtl) (uint64, error) {
+	names := []string{}
+	for name := range items {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := fnv.New64a()
+	for _, name := range names {
+		data, err := protov2.MarshalOptions{Deterministic: true}.Marshal(proto.MessageV2(items[name].Resource))
+		if err != nil {
+			return 0, err
+		}
+
+		_, _ = h.Write([]byte(name))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write(data)
+	}
+
+	return h.Sum64(), nil
+}
@@ -0,0 +1,149 @@
+package envoy
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ConfigStatus is the state of one resource type of a node, what was last sent to it and what it
+// acknowledged or rejected
+type ConfigStatus struct {
+	TypeURL       string     `json:"typeUrl"`
+	InSync        bool       `json:"inSync"`
+	SentVersion   string     `json:"sentVersion,omitempty"`
+	SentAt        *time.Time `json:"sentAt,omitempty"`
+	AckedVersion  string     `json:"ackedVersion,omitempty"`
+	AckedAt       *time.Time `json:"ackedAt,omitempty"`
+	NackedVersion string     `json:"nackedVersion,omitempty"`
+	NackError     string     `json:"nackError,omitempty"`
+	NackedAt      *time.Time `json:"nackedAt,omitempty"`
+
+	// nonce of the last response, envoy acknowledges or rejects it in a request carrying the same nonce
+	nonce string
+}
+
+// NodeConfigStatus is the state of every resource type of a node
+type NodeConfigStatus struct {
+	Node  string         `json:"node"`
+	Types []ConfigStatus `json:"types"`
+}
+
+// recordResponse remembers the version sent to the node of the stream, must be called with mu held
+func (cb *Callbacks) recordResponse(id int64, typeURL, version, nonce string) {
+	nodeID, ok := cb.streams[id]
+	if !ok {
+		return
+	}
+
+	if cb.configStatus[nodeID] == nil {
+		cb.configStatus[nodeID] = map[string]*ConfigStatus{}
+	}
+
+	status, ok := cb.configStatus[nodeID][typeURL]
+	if !ok {
+		status = &ConfigStatus{TypeURL: typeURL}
+		cb.configStatus[nodeID][typeURL] = status
+	}
+
+	now := time.Now()
+	status.SentVersion = version
+	status.SentAt = &now
+	status.InSync = false
+	status.nonce = nonce
+
+	configInSync.WithLabelValues(nodeID, shortTypeName(typeURL)).Set(0)
+}
+
+// recordRequest records the acknowledgement or rejection of the last response sent to the node of the
+// stream, a request carrying error details rejects it, must be called with mu held
+func (cb *Callbacks) recordRequest(id int64, typeURL, nonce string, nacked bool, nackError string) {
+	// Note: requests without a nonce are initial requests or new subscriptions
+	if nonce == "" {
+		return
+	}
+
+	nodeID, ok := cb.streams[id]
+	if !ok {
+		return
+	}
+
+	status, ok := cb.configStatus[nodeID][typeURL]
+	if !ok || status.nonce != nonce {
+		return
+	}
+	status.nonce = ""
+
+	now := time.Now()
+	typ := shortTypeName(typeURL)
+
+	if nacked {
+		status.NackedVersion = status.SentVersion
+		status.NackError = nackError
+		status.NackedAt = &now
+
+		configNacks.WithLabelValues(nodeID, typ).Inc()
+		cb.log.WithField("node", nodeID).WithField("type", typeURL).WithField("version", status.SentVersion).WithField("error", status.NackError).Warn("envoy rejected configuration")
+		return
+	}
+
+	status.AckedVersion = status.SentVersion
+	status.AckedAt = &now
+	status.InSync = true
+
+	configInSync.WithLabelValues(nodeID, typ).Set(1)
+	configLastAck.WithLabelValues(nodeID, typ).Set(float64(now.Unix()))
+}
+
+// forgetConfigStatus removes the status of a node that closed its last stream, must be called with mu held
+func (cb *Callbacks) forgetConfigStatus(nodeID string) {
+	for typeURL := range cb.configStatus[nodeID] {
+		typ := shortTypeName(typeURL)
+		configInSync.DeleteLabelValues(nodeID, typ)
+		configLastAck.DeleteLabelValues(nodeID, typ)
+		configNacks.DeleteLabelValues(nodeID, typ)
+	}
+	delete(cb.configStatus, nodeID)
+}
+
+// ConfigStatus returns the status of every connected node ordered by node id
+func (cb *Callbacks) ConfigStatus() []NodeConfigStatus {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	nodes := []NodeConfigStatus{}
+	for nodeID, types := range cb.configStatus {
+		node := NodeConfigStatus{Node: nodeID, Types: []ConfigStatus{}}
+		for _, status := range types {
+			node.Types = append(node.Types, *status)
+		}
+		sort.Slice(node.Types, func(i, j int) bool {
+			return node.Types[i].TypeURL < node.Types[j].TypeURL
+		})
+		nodes = append(nodes, node)
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Node < nodes[j].Node
+	})
+
+	return nodes
+}
+
+// configStatusHandler serves the config status of the connected nodes as JSON
+func (cb *Callbacks) configStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(cb.ConfigStatus()); err != nil {
+		cb.log.WithError(err).Error("unable to encode config status")
+	}
+}
+
+// shortTypeName returns the message name of a type url, e.g. Cluster for the cluster type
+func shortTypeName(typeURL string) string {
+	return typeURL[strings.LastIndex(typeURL, ".")+1:]
+}
@@ -16,8 +16,14 @@ import (
 var EnvoyAdsRegistry = prometheus.NewRegistry()
 var AtlasRegistry = prometheus.NewRegistry()
 
+// Handler is an additional endpoint served by the metrics server
+type Handler struct {
+	Path    string
+	Handler http.Handler
+}
+
 // NewMetricsServer --
-func NewMetricsServer(ctx context.Context, port string, debug bool, registry *prometheus.Registry) error {
+func NewMetricsServer(ctx context.Context, port string, debug bool, registry *prometheus.Registry, handlers ...Handler) error {
 	if registry != nil {
 		registry.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
 		registry.MustRegister(prometheus.NewGoCollector())
@@ -38,6 +44,10 @@ func NewMetricsServer(ctx context.Context, port string, debug bool, registry *pr
 		router.Path("/metrics").Handler(handler)
 	}
 
+	for _, h := range handlers {
+		router.Path(h.Path).Handler(h.Handler)
+	}
+
 	if debug {
 		// Register pprof handlers
 		router.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
@@ -0,0 +1,67 @@
+package pki
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"time"
+)
+
+// IssuanceRecord describes a certificate minted by Atlas
+type IssuanceRecord struct {
+	Serial       string    `json:"serial"`
+	CommonName   string    `json:"commonName"`
+	Usages       []string  `json:"usages"`
+	IsCA         bool      `json:"isCA"`
+	NotBefore    time.Time `json:"notBefore"`
+	NotAfter     time.Time `json:"notAfter"`
+	IssuerSerial string    `json:"issuerSerial"`
+	IssuedAt     time.Time `json:"issuedAt"`
+}
+
+// NewIssuanceRecord builds the issuance record of a certificate signed by the CA with the given serial
+func NewIssuanceRecord(cert *x509.Certificate, issuerSerial string) IssuanceRecord {
+	usages := []string{}
+	for _, u := range cert.ExtKeyUsage {
+		switch u {
+		case x509.ExtKeyUsageServerAuth:
+			usages = append(usages, "server auth")
+		case x509.ExtKeyUsageClientAuth:
+			usages = append(usages, "client auth")
+		}
+	}
+	if cert.IsCA {
+		usages = append(usages, "cert sign")
+	}
+
+	return IssuanceRecord{
+		Serial:       cert.SerialNumber.String(),
+		CommonName:   cert.Subject.CommonName,
+		Usages:       usages,
+		IsCA:         cert.IsCA,
+		NotBefore:    cert.NotBefore.UTC(),
+		NotAfter:     cert.NotAfter.UTC(),
+		IssuerSerial: issuerSerial,
+		IssuedAt:     time.Now().UTC(),
+	}
+}
+
+// Encode returns the JSON representation of the record
+func (r IssuanceRecord) Encode() (string, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// DecodeIssuanceRecord parses the JSON representation of a record
+func DecodeIssuanceRecord(data string) (IssuanceRecord, error) {
+	var r IssuanceRecord
+	err := json.Unmarshal([]byte(data), &r)
+	return r, err
+}
+
+// Expired returns true once the certificate of the record is no longer valid
+func (r IssuanceRecord) Expired(now time.Time) bool {
+	return now.After(r.NotAfter)
+}
@@ -0,0 +1,213 @@
+package pki
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/goatlas-io/atlas/pkg/common"
+)
+
+// CA describes a certificate authority that is part of the Atlas trust bundle
+type CA struct {
+	Key       string
+	Serial    string
+	NotBefore time.Time
+	NotAfter  time.Time
+	Active    bool
+	RetiredAt *time.Time
+}
+
+// Expired returns true if the certificate authority is past its expiry
+func (ca CA) Expired(now time.Time) bool {
+	return now.After(ca.NotAfter)
+}
+
+// Status returns a short human readable description of the state of the certificate authority
+func (ca CA) Status(now time.Time) string {
+	switch {
+	case ca.Active:
+		return "active"
+	case ca.Expired(now):
+		return "expired"
+	case ca.RetiredAt != nil:
+		return "retired"
+	}
+	return "trusted"
+}
+
+// List returns every certificate authority in the CA secret, the active one first followed
+// by the previous ones ordered by expiry
+func List(secret *corev1.Secret) ([]CA, error) {
+	retired, err := Retired(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	cas := []CA{}
+	for k, v := range secret.Data {
+		if !isCAKey(k) {
+			continue
+		}
+
+		block, _ := pem.Decode(v)
+		if block == nil {
+			return nil, fmt.Errorf("failed to parse certificate PEM in %s", k)
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		ca := CA{
+			Key:       k,
+			Serial:    cert.SerialNumber.String(),
+			NotBefore: cert.NotBefore,
+			NotAfter:  cert.NotAfter,
+			Active:    k == "ca.pem",
+		}
+		if t, ok := retired[ca.Serial]; ok && !ca.Active {
+			ca.RetiredAt = &t
+		}
+
+		cas = append(cas, ca)
+	}
+
+	sort.Slice(cas, func(i, j int) bool {
+		if cas[i].Active != cas[j].Active {
+			return cas[i].Active
+		}
+		return cas[i].NotAfter.After(cas[j].NotAfter)
+	})
+
+	return cas, nil
+}
+
+// Retired returns the serials of the explicitly retired certificate authorities and when they were retired
+func Retired(secret *corev1.Secret) (map[string]time.Time, error) {
+	retired := map[string]time.Time{}
+
+	v, ok := secret.GetAnnotations()[common.CARetiredAnnotation]
+	if !ok || v == "" {
+		return retired, nil
+	}
+
+	if err := json.Unmarshal([]byte(v), &retired); err != nil {
+		return nil, fmt.Errorf("unable to parse %s annotation: %w", common.CARetiredAnnotation, err)
+	}
+
+	return retired, nil
+}
+
+// Retire marks the certificate authority with the given serial as retired, the active
+// certificate authority cannot be retired
+func Retire(secret *corev1.Secret, serial string, now time.Time) error {
+	cas, err := List(secret)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, ca := range cas {
+		if ca.Serial != serial {
+			continue
+		}
+		if ca.Active {
+			return fmt.Errorf("certificate authority %s is active, rotate it before retiring it", serial)
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("certificate authority %s is not part of the trust bundle", serial)
+	}
+
+	retired, err := Retired(secret)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := retired[serial]; ok {
+		return nil
+	}
+	retired[serial] = now.UTC()
+
+	return setRetired(secret, retired)
+}
+
+// Prune removes the certificate authorities that have been expired or retired for longer than
+// the grace period from the secret and returns their serials. The active CA is never removed.
+func Prune(secret *corev1.Secret, grace time.Duration, now time.Time) ([]string, error) {
+	cas, err := List(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	retired, err := Retired(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	pruned := []string{}
+	present := map[string]bool{}
+	for _, ca := range cas {
+		present[ca.Serial] = true
+
+		if ca.Active {
+			continue
+		}
+
+		expired := now.After(ca.NotAfter.Add(grace))
+		retiredOut := ca.RetiredAt != nil && now.After(ca.RetiredAt.Add(grace))
+		if !expired && !retiredOut {
+			continue
+		}
+
+		delete(secret.Data, ca.Key)
+		delete(secret.Data, CAKeyName(ca))
+		delete(retired, ca.Serial)
+		pruned = append(pruned, ca.Serial)
+	}
+
+	// Forget retirements of certificate authorities that are no longer in the bundle
+	for serial := range retired {
+		if !present[serial] {
+			delete(retired, serial)
+		}
+	}
+
+	if err := setRetired(secret, retired); err != nil {
+		return nil, err
+	}
+
+	return pruned, nil
+}
+
+func setRetired(secret *corev1.Secret, retired map[string]time.Time) error {
+	if len(retired) == 0 {
+		delete(secret.Annotations, common.CARetiredAnnotation)
+		return nil
+	}
+
+	data, err := json.Marshal(retired)
+	if err != nil {
+		return err
+	}
+
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[common.CARetiredAnnotation] = string(data)
+
+	return nil
+}
+
+func isCAKey(key string) bool {
+	return key == "ca.pem" || (strings.HasPrefix(key, "ca-") && strings.HasSuffix(key, ".pem") && !strings.HasPrefix(key, "ca-key"))
+}
@@ -0,0 +1,163 @@
+package pki
+
+import (
+	"crypto/x509/pkix"
+	"net"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var (
+	CertificateGVK = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Certificate"}
+	IssuerGVK      = schema.GroupVersionKind{Group: "cert-manager.io", Version: "v1", Kind: "Issuer"}
+)
+
+// IssuerRef references the cert-manager Issuer or ClusterIssuer that signs a certificate
+type IssuerRef struct {
+	Name string
+	Kind string
+}
+
+// CertificateSpec describes a certificate requested through cert-manager
+type CertificateSpec struct {
+	Name        string
+	Namespace   string
+	SecretName  string
+	CommonName  string
+	IsCA        bool
+	Usages      []string
+	Issuer      IssuerRef
+	Duration    time.Duration
+	RenewBefore time.Duration
+
+	// Subject and KeyAlgorithm follow the same settings as certificates signed by Atlas
+	Subject      pkix.Name
+	KeyAlgorithm string
+
+	// SecretLabels are added to the secret cert-manager writes, Atlas relies on them to find its certificates
+	SecretLabels map[string]string
+}
+
+// NewCertificate builds a cert-manager Certificate resource, the common name is also added as
+// an IP or DNS SAN the same way Atlas does for the certificates it signs itself
+func NewCertificate(spec CertificateSpec) *unstructured.Unstructured {
+	usages := []interface{}{}
+	for _, u := range spec.Usages {
+		usages = append(usages, u)
+	}
+
+	labels := map[string]interface{}{}
+	for k, v := range spec.SecretLabels {
+		labels[k] = v
+	}
+
+	s := map[string]interface{}{
+		"secretName": spec.SecretName,
+		"commonName": spec.CommonName,
+		"isCA":       spec.IsCA,
+		"usages":     usages,
+		"issuerRef": map[string]interface{}{
+			"name":  spec.Issuer.Name,
+			"kind":  spec.Issuer.Kind,
+			"group": CertificateGVK.Group,
+		},
+		"privateKey": privateKey(spec.KeyAlgorithm),
+		"secretTemplate": map[string]interface{}{
+			"labels": labels,
+		},
+	}
+
+	if subject := subjectFields(spec.Subject); len(subject) > 0 {
+		s["subject"] = subject
+	}
+
+	if ip := net.ParseIP(spec.CommonName); ip != nil {
+		s["ipAddresses"] = []interface{}{spec.CommonName}
+	} else {
+		s["dnsNames"] = []interface{}{spec.CommonName}
+	}
+
+	if spec.Duration > 0 {
+		s["duration"] = spec.Duration.String()
+	}
+	if spec.RenewBefore > 0 {
+		s["renewBefore"] = spec.RenewBefore.String()
+	}
+
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{"spec": s}}
+	obj.SetGroupVersionKind(CertificateGVK)
+	obj.SetName(spec.Name)
+	obj.SetNamespace(spec.Namespace)
+
+	return obj
+}
+
+func privateKey(algorithm string) map[string]interface{} {
+	key := map[string]interface{}{
+		"rotationPolicy": "Always",
+		"encoding":       "PKCS8",
+	}
+
+	switch algorithm {
+	case KeyAlgorithmRSA2048:
+		key["algorithm"], key["size"] = "RSA", int64(2048)
+	case KeyAlgorithmRSA3072:
+		key["algorithm"], key["size"] = "RSA", int64(3072)
+	case KeyAlgorithmRSA4096:
+		key["algorithm"], key["size"] = "RSA", int64(4096)
+	case KeyAlgorithmECDSAP256:
+		key["algorithm"], key["size"] = "ECDSA", int64(256)
+	case KeyAlgorithmECDSAP384:
+		key["algorithm"], key["size"] = "ECDSA", int64(384)
+	case KeyAlgorithmEd25519:
+		key["algorithm"] = "Ed25519"
+	}
+
+	return key
+}
+
+func subjectFields(name pkix.Name) map[string]interface{} {
+	fields := map[string]interface{}{}
+
+	add := func(key string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		list := []interface{}{}
+		for _, v := range values {
+			list = append(list, v)
+		}
+		fields[key] = list
+	}
+
+	add("organizations", name.Organization)
+	add("organizationalUnits", name.OrganizationalUnit)
+	add("countries", name.Country)
+	add("provinces", name.Province)
+	add("localities", name.Locality)
+	add("streetAddresses", name.StreetAddress)
+	add("postalCodes", name.PostalCode)
+	if name.SerialNumber != "" {
+		fields["serialNumber"] = name.SerialNumber
+	}
+
+	return fields
+}
+
+// NewCAIssuer builds a cert-manager Issuer that signs certificates with the keypair in the given TLS secret
+func NewCAIssuer(name, namespace, secretName string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"ca": map[string]interface{}{
+				"secretName": secretName,
+			},
+		},
+	}}
+	obj.SetGroupVersionKind(IssuerGVK)
+	obj.SetName(name)
+	obj.SetNamespace(namespace)
+
+	return obj
+}
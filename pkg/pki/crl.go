@@ -0,0 +1,309 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/goatlas-io/atlas/pkg/common"
+)
+
+// Revocation describes a revoked certificate. The expiry and the serial of the issuing CA are unknown for
+// certificates revoked by serial without an issuance record and for revocations of earlier releases.
+type Revocation struct {
+	RevokedAt time.Time  `json:"revokedAt"`
+	NotAfter  *time.Time `json:"notAfter,omitempty"`
+	Issuer    string     `json:"issuer,omitempty"`
+}
+
+// Revocations returns the revoked certificates recorded on the CA secret keyed by serial
+func Revocations(secret *corev1.Secret) (map[string]Revocation, error) {
+	revocations := map[string]Revocation{}
+
+	v, ok := secret.GetAnnotations()[common.CARevokedAnnotation]
+	if !ok || v == "" {
+		return revocations, nil
+	}
+
+	entries := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(v), &entries); err != nil {
+		return nil, fmt.Errorf("unable to parse %s annotation: %w", common.CARevokedAnnotation, err)
+	}
+
+	for serial, entry := range entries {
+		var revocation Revocation
+
+		// Note: earlier releases only recorded when the certificate was revoked
+		if err := json.Unmarshal(entry, &revocation.RevokedAt); err != nil {
+			if err := json.Unmarshal(entry, &revocation); err != nil {
+				return nil, fmt.Errorf("unable to parse %s annotation: %w", common.CARevokedAnnotation, err)
+			}
+		}
+
+		revocations[serial] = revocation
+	}
+
+	return revocations, nil
+}
+
+// Revoked returns the revoked certificate serials recorded on the CA secret and when they were revoked
+func Revoked(secret *corev1.Secret) (map[string]time.Time, error) {
+	revocations, err := Revocations(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked := map[string]time.Time{}
+	for serial, revocation := range revocations {
+		revoked[serial] = revocation.RevokedAt
+	}
+
+	return revoked, nil
+}
+
+// Revoke records the certificate serial as revoked on the CA secret
+func Revoke(secret *corev1.Secret, serial string, revocation Revocation) error {
+	if _, ok := new(big.Int).SetString(serial, 10); !ok {
+		return fmt.Errorf("invalid certificate serial %q", serial)
+	}
+
+	revocations, err := Revocations(secret)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := revocations[serial]; ok {
+		return nil
+	}
+	revocation.RevokedAt = revocation.RevokedAt.UTC()
+	revocations[serial] = revocation
+
+	return setRevocations(secret, revocations)
+}
+
+// CertificateRevocation returns the revocation of the certificate, the issuer is the CA of the trust bundle
+// that signed it
+func CertificateRevocation(secret *corev1.Secret, cert *x509.Certificate, now time.Time) Revocation {
+	notAfter := cert.NotAfter.UTC()
+	revocation := Revocation{RevokedAt: now, NotAfter: &notAfter}
+
+	for k, v := range secret.Data {
+		if !isCAKey(k) || k == common.CAIssuerKey {
+			continue
+		}
+
+		ca, err := parseCertificate(v)
+		if err != nil {
+			continue
+		}
+		if cert.CheckSignatureFrom(ca) == nil {
+			revocation.Issuer = ca.SerialNumber.String()
+		}
+	}
+
+	return revocation
+}
+
+// PruneRevoked forgets the revoked certificates that no longer need to be part of a CRL and returns their
+// serials: certificates that expired and certificates whose issuing CA was pruned from the trust bundle.
+// When the issuer is unknown, it was pruned once every CA in the bundle was issued after the revocation.
+func PruneRevoked(secret *corev1.Secret, now time.Time) ([]string, error) {
+	revocations, err := Revocations(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	cas, err := List(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	present := map[string]bool{}
+	for _, ca := range cas {
+		present[ca.Serial] = true
+	}
+
+	pruned := []string{}
+	for serial, revocation := range revocations {
+		expired := revocation.NotAfter != nil && now.After(*revocation.NotAfter)
+		issuerPruned := revocation.Issuer != "" && !present[revocation.Issuer]
+
+		if revocation.Issuer == "" {
+			issuerPruned = true
+			for _, ca := range cas {
+				if ca.Key != common.CAIssuerKey && !ca.NotBefore.After(revocation.RevokedAt) {
+					issuerPruned = false
+				}
+			}
+		}
+
+		if expired || issuerPruned {
+			delete(revocations, serial)
+			pruned = append(pruned, serial)
+		}
+	}
+
+	sort.Strings(pruned)
+
+	if err := setRevocations(secret, revocations); err != nil {
+		return nil, err
+	}
+
+	return pruned, nil
+}
+
+func setRevocations(secret *corev1.Secret, revocations map[string]Revocation) error {
+	if len(revocations) == 0 {
+		delete(secret.Annotations, common.CARevokedAnnotation)
+		return nil
+	}
+
+	data, err := json.Marshal(revocations)
+	if err != nil {
+		return err
+	}
+
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	secret.Annotations[common.CARevokedAnnotation] = string(data)
+
+	return nil
+}
+
+// CAKeyName returns the key of the CA secret holding the private key of the given CA
+func CAKeyName(ca CA) string {
+	if ca.Active {
+		return "ca-key.pem"
+	}
+	return fmt.Sprintf("ca-key-%s.pem", ca.Serial)
+}
+
+// CanSignCRL returns true when the private key of the CA is available and the CA is allowed to sign CRLs
+func CanSignCRL(secret *corev1.Secret, ca CA) (bool, error) {
+	if _, ok := secret.Data[CAKeyName(ca)]; !ok {
+		return false, nil
+	}
+
+	cert, err := parseCertificate(secret.Data[ca.Key])
+	if err != nil {
+		return false, err
+	}
+
+	return cert.KeyUsage&x509.KeyUsageCRLSign != 0, nil
+}
+
+// CRLUncovered returns the serials of the CAs in the trust bundle that issue leaf certificates but that a
+// CRL cannot be signed for. Envoy only checks the CRL of the issuer of the leaf, so the issuer chain of an
+// external intermediate does not need one.
+func CRLUncovered(secret *corev1.Secret) ([]string, error) {
+	cas, err := List(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	uncovered := []string{}
+	for _, ca := range cas {
+		if ca.Key == common.CAIssuerKey {
+			continue
+		}
+
+		ok, err := CanSignCRL(secret, ca)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			uncovered = append(uncovered, ca.Serial)
+		}
+	}
+
+	return uncovered, nil
+}
+
+// BuildCRLs returns PEM encoded CRLs listing every revoked serial, one for each CA in the trust bundle
+// that a CRL can be signed for. Envoy requires a CRL for the issuer of every leaf once any CRL is configured,
+// so the CRLs are only useful when CRLUncovered returns no CAs.
+func BuildCRLs(secret *corev1.Secret, now time.Time, validity time.Duration) ([]byte, error) {
+	revoked, err := Revoked(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	cas, err := List(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	serials := []string{}
+	for serial := range revoked {
+		serials = append(serials, serial)
+	}
+	sort.Strings(serials)
+
+	entries := []pkix.RevokedCertificate{}
+	for _, serial := range serials {
+		n, ok := new(big.Int).SetString(serial, 10)
+		if !ok {
+			continue
+		}
+		entries = append(entries, pkix.RevokedCertificate{
+			SerialNumber:   n,
+			RevocationTime: revoked[serial],
+		})
+	}
+
+	var buf bytes.Buffer
+	for _, ca := range cas {
+		ok, err := CanSignCRL(secret, ca)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		cert, err := parseCertificate(secret.Data[ca.Key])
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := ParsePrivateKey(secret.Data[CAKeyName(ca)])
+		if err != nil {
+			return nil, err
+		}
+
+		crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+			RevokedCertificates: entries,
+			Number:              big.NewInt(now.Unix()),
+			ThisUpdate:          now,
+			NextUpdate:          now.Add(validity),
+		}, cert, key)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := pem.Encode(&buf, &pem.Block{Type: "X509 CRL", Bytes: crl}); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to parse certificate PEM")
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
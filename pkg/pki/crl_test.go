@@ -0,0 +1,280 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/goatlas-io/atlas/pkg/common"
+)
+
+// testCA is a certificate authority along with the PEM encoding of its certificate and key
+type testCA struct {
+	cert   *x509.Certificate
+	key    crypto.Signer
+	crtPEM []byte
+	keyPEM []byte
+}
+
+// newTestCA issues a certificate authority with the key usage, self-signed unless a parent is given
+func newTestCA(t *testing.T, serial int64, usage x509.KeyUsage, parent *testCA) *testCA {
+	t.Helper()
+
+	key, err := GenerateKey(KeyAlgorithmECDSAP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: fmt.Sprintf("ca-%d", serial)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              usage,
+		BasicConstraintsValid: true,
+	}
+
+	issuer, signer := template, key
+	if parent != nil {
+		issuer, signer = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyPEM, err := EncodePrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCA{
+		cert:   cert,
+		key:    key,
+		crtPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM: keyPEM,
+	}
+}
+
+// parseCRLs returns the issuers of the PEM encoded CRLs after checking their signatures
+func parseCRLs(t *testing.T, data []byte, cas ...*testCA) []string {
+	t.Helper()
+
+	issuers := []string{}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		crl, err := x509.ParseCRL(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		issuer := ""
+		for _, ca := range cas {
+			if ca.cert.CheckCRLSignature(crl) == nil {
+				issuer = ca.cert.Subject.CommonName
+			}
+		}
+		if issuer == "" {
+			t.Fatal("CRL is not signed by a trusted CA")
+		}
+
+		issuers = append(issuers, issuer)
+	}
+
+	return issuers
+}
+
+func TestCRLCoverage(t *testing.T) {
+	const canSign = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	const cannotSign = x509.KeyUsageCertSign
+
+	root := newTestCA(t, 100, canSign, nil)
+
+	tests := []struct {
+		name      string
+		secret    func() map[string][]byte
+		uncovered []string
+		signed    []string
+	}{
+		{
+			name: "self-signed",
+			secret: func() map[string][]byte {
+				ca := newTestCA(t, 1, canSign, nil)
+				return map[string][]byte{"ca.pem": ca.crtPEM, "ca-key.pem": ca.keyPEM}
+			},
+			uncovered: []string{},
+			signed:    []string{"ca-1"},
+		},
+		{
+			name: "self-signed by an earlier release",
+			secret: func() map[string][]byte {
+				ca := newTestCA(t, 1, cannotSign, nil)
+				return map[string][]byte{"ca.pem": ca.crtPEM, "ca-key.pem": ca.keyPEM}
+			},
+			uncovered: []string{"1"},
+			signed:    []string{},
+		},
+		{
+			name: "self-signed rotated from an earlier release",
+			secret: func() map[string][]byte {
+				ca := newTestCA(t, 2, canSign, nil)
+				previous := newTestCA(t, 1, cannotSign, nil)
+				return map[string][]byte{
+					"ca.pem": ca.crtPEM, "ca-key.pem": ca.keyPEM,
+					"ca-1.pem": previous.crtPEM, "ca-key-1.pem": previous.keyPEM,
+				}
+			},
+			uncovered: []string{"1"},
+			signed:    []string{"ca-2"},
+		},
+		{
+			name: "secret with a root CA",
+			secret: func() map[string][]byte {
+				ca := newTestCA(t, 1, canSign, nil)
+				return map[string][]byte{"ca.pem": ca.crtPEM, "ca-key.pem": ca.keyPEM}
+			},
+			uncovered: []string{},
+			signed:    []string{"ca-1"},
+		},
+		{
+			name: "secret without the crl sign key usage",
+			secret: func() map[string][]byte {
+				ca := newTestCA(t, 1, cannotSign, root)
+				return map[string][]byte{"ca.pem": ca.crtPEM, "ca-key.pem": ca.keyPEM, common.CAIssuerKey: root.crtPEM}
+			},
+			uncovered: []string{"1"},
+			signed:    []string{},
+		},
+		{
+			name: "cert-manager",
+			secret: func() map[string][]byte {
+				ca := newTestCA(t, 1, canSign, root)
+				return map[string][]byte{"ca.pem": ca.crtPEM, "ca-key.pem": ca.keyPEM, common.CAIssuerKey: root.crtPEM}
+			},
+			uncovered: []string{},
+			signed:    []string{"ca-1"},
+		},
+		{
+			name: "cert-manager renewed",
+			secret: func() map[string][]byte {
+				ca := newTestCA(t, 2, canSign, root)
+				previous := newTestCA(t, 1, canSign, root)
+				return map[string][]byte{
+					"ca.pem": ca.crtPEM, "ca-key.pem": ca.keyPEM, common.CAIssuerKey: root.crtPEM,
+					"ca-1.pem": previous.crtPEM, "ca-key-1.pem": previous.keyPEM,
+				}
+			},
+			uncovered: []string{},
+			signed:    []string{"ca-2", "ca-1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			secret := &corev1.Secret{Data: test.secret()}
+			if err := Revoke(secret, "42", Revocation{RevokedAt: time.Now()}); err != nil {
+				t.Fatal(err)
+			}
+
+			uncovered, err := CRLUncovered(secret)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(uncovered) != fmt.Sprint(test.uncovered) {
+				t.Errorf("expected uncovered CAs %v, got %v", test.uncovered, uncovered)
+			}
+
+			crls, err := BuildCRLs(secret, time.Now(), time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cas := []*testCA{}
+			for k, v := range secret.Data {
+				if !isCAKey(k) {
+					continue
+				}
+				cert, err := parseCertificate(v)
+				if err != nil {
+					t.Fatal(err)
+				}
+				cas = append(cas, &testCA{cert: cert})
+			}
+
+			if signed := parseCRLs(t, crls, cas...); fmt.Sprint(signed) != fmt.Sprint(test.signed) {
+				t.Errorf("expected CRLs of %v, got %v", test.signed, signed)
+			}
+		})
+	}
+}
+
+func TestPruneRevoked(t *testing.T) {
+	now := time.Now()
+	ca := newTestCA(t, 1, x509.KeyUsageCertSign|x509.KeyUsageCRLSign, nil)
+	leaf := newTestCA(t, 7, x509.KeyUsageDigitalSignature, ca)
+
+	revocation := CertificateRevocation(&corev1.Secret{Data: map[string][]byte{"ca.pem": ca.crtPEM}}, leaf.cert, now)
+	if revocation.Issuer != "1" {
+		t.Fatalf("expected the revocation to be issued by CA 1, got %q", revocation.Issuer)
+	}
+
+	expired := now.Add(-time.Minute)
+	valid := now.Add(time.Hour)
+
+	secret := &corev1.Secret{Data: map[string][]byte{"ca.pem": ca.crtPEM, "ca-key.pem": ca.keyPEM}}
+	secret.Annotations = map[string]string{
+		// Note: the entries without expiry and issuer are written by earlier releases
+		common.CARevokedAnnotation: fmt.Sprintf(`{"10":%q,"11":%q}`, now.Add(-2*time.Hour).Format(time.RFC3339), now.Add(-30*time.Minute).Format(time.RFC3339)),
+	}
+
+	for serial, revocation := range map[string]Revocation{
+		"12": {RevokedAt: now, NotAfter: &expired, Issuer: "1"},
+		"13": {RevokedAt: now, NotAfter: &valid, Issuer: "1"},
+		"14": {RevokedAt: now, NotAfter: &valid, Issuer: "2"},
+		"15": {RevokedAt: now},
+	} {
+		if err := Revoke(secret, serial, revocation); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pruned, err := PruneRevoked(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 10 was revoked before CA 1 was issued, 12 expired and the issuer of 14 is no longer trusted
+	if fmt.Sprint(pruned) != "[10 12 14]" {
+		t.Errorf("expected revocations [10 12 14] to be pruned, got %v", pruned)
+	}
+
+	revoked, err := Revoked(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 3 {
+		t.Errorf("expected revocations 11, 13 and 15 to be kept, got %v", revoked)
+	}
+
+	delete(secret.Data, "ca.pem")
+	if _, err := PruneRevoked(secret, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := secret.Annotations[common.CARevokedAnnotation]; ok {
+		t.Errorf("expected every revocation to be pruned with its CA, got %s", secret.Annotations[common.CARevokedAnnotation])
+	}
+}
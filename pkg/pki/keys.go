@@ -0,0 +1,126 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// ParsePrivateKey parses a PEM encoded PKCS#1, SEC 1 or PKCS#8 private key
+func ParsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed to parse private key PEM")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+
+		return signer, nil
+	}
+
+	return nil, fmt.Errorf("unsupported private key PEM type %q", block.Type)
+}
+
+const (
+	KeyAlgorithmRSA2048   = "rsa-2048"
+	KeyAlgorithmRSA3072   = "rsa-3072"
+	KeyAlgorithmRSA4096   = "rsa-4096"
+	KeyAlgorithmECDSAP256 = "ecdsa-p256"
+	KeyAlgorithmECDSAP384 = "ecdsa-p384"
+	KeyAlgorithmEd25519   = "ed25519"
+)
+
+// KeyAlgorithms lists the supported key algorithms, ed25519 is only supported for the CA
+var KeyAlgorithms = []string{
+	KeyAlgorithmRSA2048,
+	KeyAlgorithmRSA3072,
+	KeyAlgorithmRSA4096,
+	KeyAlgorithmECDSAP256,
+	KeyAlgorithmECDSAP384,
+	KeyAlgorithmEd25519,
+}
+
+// IsKeyAlgorithm returns true when the key algorithm is supported
+func IsKeyAlgorithm(algorithm string) bool {
+	for _, a := range KeyAlgorithms {
+		if a == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// LeafKeyAlgorithms lists the key algorithms leaf certificates can be issued with, envoy only serves
+// rsa and ecdsa certificates
+var LeafKeyAlgorithms = []string{
+	KeyAlgorithmRSA2048,
+	KeyAlgorithmRSA3072,
+	KeyAlgorithmRSA4096,
+	KeyAlgorithmECDSAP256,
+	KeyAlgorithmECDSAP384,
+}
+
+// IsLeafKeyAlgorithm returns true when leaf certificates can be issued with the key algorithm
+func IsLeafKeyAlgorithm(algorithm string) bool {
+	for _, a := range LeafKeyAlgorithms {
+		if a == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateKey generates a private key using one of the supported key algorithms
+func GenerateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case KeyAlgorithmRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyAlgorithmRSA3072:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case KeyAlgorithmRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case KeyAlgorithmECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyAlgorithmECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyAlgorithmEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+
+	return nil, fmt.Errorf("unsupported key algorithm %q, valid options are: %s", algorithm, strings.Join(KeyAlgorithms, ", "))
+}
+
+// EncodePrivateKey encodes a private key as PKCS#8 PEM
+func EncodePrivateKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: der,
+	}), nil
+}
@@ -0,0 +1,23 @@
+package pki
+
+import (
+	"crypto/rand"
+	"math/big"
+)
+
+var serialLimit = new(big.Int).Lsh(big.NewInt(1), 128)
+
+// NewSerial returns a cryptographically random 128-bit certificate serial number
+func NewSerial() (*big.Int, error) {
+	for {
+		serial, err := rand.Int(rand.Reader, serialLimit)
+		if err != nil {
+			return nil, err
+		}
+
+		// Serials must be positive
+		if serial.Sign() > 0 {
+			return serial, nil
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// DefaultSubjectTemplate is the subject Atlas has always used for its certificates
+const DefaultSubjectTemplate = "O=goatlas.io,OU=Atlas,C=US,ST=DC,L=Washington"
+
+// Subject renders a subject template and parses the result as a distinguished name, for example
+// "O=Example,OU={{ .CommonName }},C=US". The common name is used as CN unless the template sets it.
+func Subject(subjectTemplate, commonName string) (pkix.Name, error) {
+	name := pkix.Name{}
+
+	tmpl, err := template.New("subject").Option("missingkey=error").Parse(subjectTemplate)
+	if err != nil {
+		return name, fmt.Errorf("unable to parse subject template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, map[string]string{"CommonName": commonName}); err != nil {
+		return name, fmt.Errorf("unable to execute subject template: %w", err)
+	}
+
+	for _, rdn := range splitDN(buf.String()) {
+		rdn = strings.TrimSpace(rdn)
+		if rdn == "" {
+			continue
+		}
+
+		parts := strings.SplitN(rdn, "=", 2)
+		if len(parts) != 2 {
+			return name, fmt.Errorf("invalid subject attribute %q, expected KEY=value", rdn)
+		}
+
+		value := strings.TrimSpace(parts[1])
+		switch strings.ToUpper(strings.TrimSpace(parts[0])) {
+		case "CN":
+			name.CommonName = value
+		case "O":
+			name.Organization = append(name.Organization, value)
+		case "OU":
+			name.OrganizationalUnit = append(name.OrganizationalUnit, value)
+		case "C":
+			name.Country = append(name.Country, value)
+		case "ST":
+			name.Province = append(name.Province, value)
+		case "L":
+			name.Locality = append(name.Locality, value)
+		case "STREET":
+			name.StreetAddress = append(name.StreetAddress, value)
+		case "POSTALCODE":
+			name.PostalCode = append(name.PostalCode, value)
+		case "SERIALNUMBER":
+			name.SerialNumber = value
+		default:
+			return name, fmt.Errorf("unsupported subject attribute %q", parts[0])
+		}
+	}
+
+	if name.CommonName == "" {
+		name.CommonName = commonName
+	}
+
+	return name, nil
+}
+
+// splitDN splits a distinguished name on commas that are not escaped with a backslash
+func splitDN(dn string) []string {
+	parts := []string{}
+
+	var current strings.Builder
+	escaped := false
+	for _, r := range dn {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ',':
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+
+	return append(parts, current.String())
+}